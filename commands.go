@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/thatoddmailbox/seafile-browse/config"
+	"github.com/thatoddmailbox/seafile-browse/seafile"
+	"golang.org/x/term"
+)
+
+// passwordEnvVar is the environment variable that the password of an encrypted library can be given in, rather than
+// typing it in. It's not taken as a flag, since those can be seen by other users on the machine.
+const passwordEnvVar = "SEAFILE_PASSWORD"
+
+type command struct {
+	name        string
+	description string
+	run         func(args []string, cfg *config.Config) int
+}
+
+var commands = []command{
+	{"fsck", "check that every library's commits, fs objects and blocks are present and readable", runFsck},
+	{"space", "report how much space each library and owner uses", runSpace},
+	{"orphans", "report stored objects that no library uses, which garbage collection would remove", runOrphans},
+	{"export", "write the files of a library to a local directory", runExport},
+	{"mirror", "update a local directory to match the latest commit of a library", runMirror},
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "usage: seafile-browse [command] [arguments]\n\n")
+	fmt.Fprintf(os.Stderr, "With no command, starts the web interface. The commands are:\n\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "\t%-10s %s\n", c.name, c.description)
+	}
+	fmt.Fprintf(os.Stderr, "\nUse \"seafile-browse [command] -h\" for more about a command.\n")
+}
+
+// runCommand runs the command with the given name, returning the exit code.
+func runCommand(name string, args []string, cfg *config.Config) int {
+	for _, c := range commands {
+		if c.name == name {
+			return c.run(args, cfg)
+		}
+	}
+
+	printUsage()
+	return 2
+}
+
+// findRepoID finds the ID of the library with the given ID or name.
+func findRepoID(storage *seafile.Storage, library string) (string, error) {
+	repoIDs, err := storage.ListRepoIDs()
+	if err != nil {
+		return "", err
+	}
+
+	matches := []string{}
+	for _, repoID := range repoIDs {
+		if repoID == library {
+			return repoID, nil
+		}
+
+		inf, err := storage.GetRepoInfo(repoID)
+		if err != nil {
+			return "", err
+		}
+
+		if inf.Name == library {
+			matches = append(matches, repoID)
+		}
+	}
+
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no library with the ID or name %q", library)
+	} else if len(matches) > 1 {
+		return "", fmt.Errorf("more than one library is named %q, use one of their IDs instead: %s", library, strings.Join(matches, ", "))
+	}
+
+	return matches[0], nil
+}
+
+// readPassword gets the password of the given encrypted library from the environment, by asking for it if stdin is a
+// terminal, or otherwise from the first line of stdin.
+func readPassword(repoID string) (string, error) {
+	password, given := os.LookupEnv(passwordEnvVar)
+	if given {
+		return password, nil
+	}
+
+	stdin := int(os.Stdin.Fd())
+	if term.IsTerminal(stdin) {
+		fmt.Fprintf(os.Stderr, "Password for library %s: ", repoID)
+		passwordBytes, err := term.ReadPassword(stdin)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		return string(passwordBytes), nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && (line == "" || !errors.Is(err, io.EOF)) {
+		return "", fmt.Errorf("library %s is encrypted, give its password on stdin or in %s", repoID, passwordEnvVar)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// openRepoForCommand opens the library with the given ID or name, even if it has been deleted, and unlocks it if needed,
+// with a password read by readPassword. It also returns the ID of the library.
+func openRepoForCommand(storage *seafile.Storage, library string) (*seafile.Repo, string, error) {
+	repoID, err := findRepoID(storage, library)
+	if err != nil {
+		return nil, "", err
+	}
+
+	repo, err := storage.OpenGarbageRepo(repoID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	encrypted, err := repo.IsEncrypted()
+	if err != nil {
+		return nil, "", err
+	}
+	if encrypted {
+		password, err := readPassword(repoID)
+		if err != nil {
+			return nil, "", err
+		}
+
+		err = repo.Unlock(password)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return repo, repoID, nil
+}
+
+// openCommitForCommand opens the library with the given ID or name, as openRepoForCommand does, and gets either the
+// Commit with the given ID, or the latest one if commitID is empty.
+func openCommitForCommand(storage *seafile.Storage, library string, commitID string) (*seafile.Commit, error) {
+	repo, repoID, err := openRepoForCommand(storage, library)
+	if err != nil {
+		return nil, err
+	}
+
+	var commit *seafile.Commit
+	if commitID != "" {
+		commit, err = repo.GetCommit(commitID)
+	} else {
+		commit, err = repo.GetLatestCommit()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if commit == nil {
+		return nil, fmt.Errorf("library %s has no commits", repoID)
+	}
+
+	return commit, nil
+}
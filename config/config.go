@@ -4,6 +4,7 @@ import (
 	"errors"
 	"io/fs"
 	"os"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/thatoddmailbox/sftpfs"
@@ -11,28 +12,41 @@ import (
 )
 
 type Config struct {
+	// VerifyContent checks every block and fs object against its ID as it's read, to catch damaged data.
+	VerifyContent bool
+
+	// MySQLDSN, if set, is used to read library metadata live from the seafile_db database, instead of a dump. It is
+	// in the format of github.com/go-sql-driver/mysql, like "user:password@tcp(host:3306)/seafile_db".
+	MySQLDSN string
+
+	// MySQLRefreshSeconds is how often the metadata from MySQLDSN is queried again. It defaults to 60.
+	MySQLRefreshSeconds int
+
 	Location struct {
 		Local *struct {
-			Path         string
-			SnapshotPath string
-			SQLFilePath  string
+			Path           string
+			SnapshotPath   string
+			SQLFilePath    string
+			SQLiteFilePath string
 		}
 		SFTP *struct {
-			Host         string
-			User         string
-			Password     string
-			Path         string
-			SnapshotPath string
-			SQLFilePath  string
+			Host           string
+			User           string
+			Password       string
+			Path           string
+			SnapshotPath   string
+			SQLFilePath    string
+			SQLiteFilePath string
 		}
 	}
 
 	path string
 
-	f       fs.FS
-	sf      fs.FS
-	sqlPath string
-	sftpFS  *sftpfs.Client
+	f          fs.FS
+	sf         fs.FS
+	sqlPath    string
+	sqlitePath string
+	sftpFS     *sftpfs.Client
 }
 
 func (c *Config) initFS() error {
@@ -45,6 +59,7 @@ func (c *Config) initFS() error {
 		}
 		c.path = c.Location.Local.Path
 		c.sqlPath = c.Location.Local.SQLFilePath
+		c.sqlitePath = c.Location.Local.SQLiteFilePath
 		return nil
 	}
 
@@ -73,6 +88,7 @@ func (c *Config) initFS() error {
 		}
 
 		c.sqlPath = c.Location.SFTP.SQLFilePath
+		c.sqlitePath = c.Location.SFTP.SQLiteFilePath
 
 		return nil
 	}
@@ -102,6 +118,26 @@ func (c *Config) SQLFilePath() string {
 	return c.sqlPath
 }
 
+func (c *Config) SQLiteFilePath() string {
+	return c.sqlitePath
+}
+
+func (c *Config) MySQLDataSource() string {
+	return c.MySQLDSN
+}
+
+func (c *Config) MySQLRefreshInterval() time.Duration {
+	if c.MySQLRefreshSeconds <= 0 {
+		return 60 * time.Second
+	}
+
+	return time.Duration(c.MySQLRefreshSeconds) * time.Second
+}
+
+func (c *Config) ShouldVerifyContent() bool {
+	return c.VerifyContent
+}
+
 func (c *Config) HaveSnapshots() bool {
 	return c.sf != nil
 }
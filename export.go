@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thatoddmailbox/seafile-browse/config"
+)
+
+// exportTempSuffix is added to the name of a file while it is being written, so that an interrupted export never
+// leaves behind a partial file that looks complete.
+const exportTempSuffix = ".seafile-export"
+
+type exportJob struct {
+	path string
+	info fs.FileInfo
+}
+
+type exporter struct {
+	fsys    fs.FS
+	dest    string
+	verbose bool
+
+	jobs      chan exportJob
+	workersWG sync.WaitGroup
+
+	lock    sync.Mutex
+	errors  []error
+	written int
+
+	skipped int
+}
+
+func (e *exporter) fail(err error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	log.Println(err)
+	e.errors = append(e.errors, err)
+}
+
+// errBadExportName is reported for a file or directory whose name, as stored, could not be used to write it, such as
+// one with a slash in it, which would put it somewhere else.
+var errBadExportName = errors.New("name is not a valid file name")
+
+// isValidExportName returns whether the given name of a file or directory in a library can be used as the name of a
+// single local file.
+func isValidExportName(name string) bool {
+	return fs.ValidPath(name) && name != "." && !strings.ContainsRune(name, '/') && !strings.ContainsRune(name, filepath.Separator)
+}
+
+func (e *exporter) localPath(p string) string {
+	return filepath.Join(e.dest, filepath.FromSlash(p))
+}
+
+// isExported returns whether the file at the given path has already been written by an earlier export. Files are only
+// given their final name and mtime once they are complete.
+func (e *exporter) isExported(p string, info fs.FileInfo) bool {
+	localInfo, err := os.Stat(e.localPath(p))
+	if err != nil {
+		return false
+	}
+
+	return localInfo.Mode().IsRegular() && localInfo.Size() == info.Size() && localInfo.ModTime().Equal(info.ModTime())
+}
+
+func (e *exporter) exportFile(job exportJob) error {
+	localPath := e.localPath(job.path)
+	tempPath := localPath + exportTempSuffix
+
+	f, err := e.fsys.Open(job.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	localFile, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(localFile, f)
+	if err != nil {
+		localFile.Close()
+		os.Remove(tempPath)
+		return err
+	}
+
+	err = localFile.Close()
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	err = os.Chtimes(tempPath, job.info.ModTime(), job.info.ModTime())
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	return os.Rename(tempPath, localPath)
+}
+
+// writeTree creates the directory at the given path in the local directory, along with the directories under it, and
+// queues every file under it that has not already been exported. It returns the directories, parents first.
+func (e *exporter) writeTree(root string) ([]exportJob, error) {
+	dirs := []exportJob{}
+
+	err := fs.WalkDir(e.fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root {
+				return err
+			}
+
+			// the directory itself was created, but its contents can't be read
+			e.fail(fmt.Errorf("%s: %w", p, err))
+			return nil
+		}
+
+		if p != root && !isValidExportName(d.Name()) {
+			// damaged, and could be written outside of the local directory
+			e.fail(fmt.Errorf("%q: %w", d.Name(), errBadExportName))
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			e.fail(fmt.Errorf("%s: %w", p, err))
+			return nil
+		}
+
+		if d.IsDir() {
+			err = os.MkdirAll(e.localPath(p), 0755)
+			if err != nil {
+				return err
+			}
+
+			dirs = append(dirs, exportJob{p, info})
+			return nil
+		}
+
+		e.queue(exportJob{p, info})
+		return nil
+	})
+
+	return dirs, err
+}
+
+// setDirTimes sets the mtimes of the given directories, which must be listed parents first. Directory mtimes change as
+// their contents are written, so this is done once everything else is.
+func (e *exporter) setDirTimes(dirs []exportJob) {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if dirs[i].info.ModTime().IsZero() {
+			continue
+		}
+
+		err := os.Chtimes(e.localPath(dirs[i].path), dirs[i].info.ModTime(), dirs[i].info.ModTime())
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			e.fail(err)
+		}
+	}
+}
+
+// queue writes the given file in the background, unless it has already been exported.
+func (e *exporter) queue(job exportJob) {
+	if e.isExported(job.path, job.info) {
+		e.skipped++
+		return
+	}
+
+	e.write(job)
+}
+
+// write writes the given file in the background, even if it looks like it has already been exported.
+func (e *exporter) write(job exportJob) {
+	e.jobs <- job
+}
+
+// start starts the given number of workers to write queued files.
+func (e *exporter) start(jobCount int) {
+	e.jobs = make(chan exportJob)
+	for i := 0; i < jobCount; i++ {
+		e.workersWG.Add(1)
+		go e.worker()
+	}
+}
+
+// wait waits for every queued file to be written.
+func (e *exporter) wait() {
+	close(e.jobs)
+	e.workersWG.Wait()
+}
+
+func (e *exporter) worker() {
+	defer e.workersWG.Done()
+
+	for job := range e.jobs {
+		err := e.exportFile(job)
+		if err != nil {
+			e.fail(fmt.Errorf("%s: %w", job.path, err))
+			continue
+		}
+
+		e.lock.Lock()
+		e.written++
+		if e.verbose {
+			fmt.Println(job.path)
+		}
+		e.lock.Unlock()
+	}
+}
+
+func runExport(args []string, cfg *config.Config) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: seafile-browse export [flags] <library> <directory>\n\n")
+		fmt.Fprintf(flags.Output(), "Writes the files of the library with the given ID or name to the directory. If an earlier export was\n")
+		fmt.Fprintf(flags.Output(), "interrupted, running the same command again continues from where it stopped.\n\n")
+		flags.PrintDefaults()
+	}
+	snapshot := flags.String("snapshot", "", "export from the given snapshot instead of the latest data")
+	commitID := flags.String("commit", "", "export the commit with the given ID, or an unambiguous prefix of it, instead of the latest one")
+	jobCount := flags.Int("j", 4, "how many files to write at once")
+	verbose := flags.Bool("v", false, "print the path of every file as it is written")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 || *jobCount < 1 {
+		flags.Usage()
+		return 2
+	}
+	library := flags.Arg(0)
+	dest := flags.Arg(1)
+
+	storage, err := openStorage(*snapshot, cfg)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	commit, err := openCommitForCommand(storage, library, *commitID)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	sfs, err := commit.GetFS()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	fmt.Printf("Exporting commit %s (%s) to %s\n", commit.CommitID, commit.Time().Format(time.RFC1123), dest)
+
+	err = os.MkdirAll(dest, 0755)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	e := exporter{
+		fsys:    sfs,
+		dest:    dest,
+		verbose: *verbose,
+	}
+
+	e.start(*jobCount)
+
+	dirs, err := e.writeTree(".")
+
+	e.wait()
+
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	e.setDirTimes(dirs)
+
+	fmt.Printf("Wrote %d files, skipped %d that were already exported.\n", e.written, e.skipped)
+
+	if len(e.errors) > 0 {
+		fmt.Printf("%d files or directories could not be read, see above.\n", len(e.errors))
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// runTestExport exports the given files to dest, which must exist, and returns the exporter to check what it did.
+func runTestExport(t *testing.T, fsys fs.FS, dest string) *exporter {
+	t.Helper()
+
+	e := &exporter{
+		fsys: fsys,
+		dest: dest,
+	}
+
+	e.start(2)
+	dirs, err := e.writeTree(".")
+	e.wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.setDirTimes(dirs)
+	return e
+}
+
+// writeLocalFiles writes the given files under dir, with the given mtime.
+func writeLocalFiles(t *testing.T, dir string, files map[string]string, mtime time.Time) {
+	t.Helper()
+
+	for p, contents := range files {
+		localPath := filepath.Join(dir, filepath.FromSlash(p))
+		err := os.MkdirAll(filepath.Dir(localPath), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(localPath, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(localPath, mtime, mtime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	first := map[string]string{"d/a.txt": "alpha", "d/empty/": ""}
+	second := map[string]string{"d/a.txt": "alpha", "d/empty/": "", "e/b.txt": "beta", "c.txt": ""}
+	_, commits := buildTestCommits(t, first, second)
+	sfs, err := commits[1].GetFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest := t.TempDir()
+	e := runTestExport(t, sfs, dest)
+	if len(e.errors) > 0 {
+		t.Fatalf("export failed: %v", e.errors)
+	}
+	if e.written != 3 || e.skipped != 0 {
+		t.Errorf("wrote %d files and skipped %d, want 3 and 0", e.written, e.skipped)
+	}
+
+	got := readMirrorTree(t, dest, time.Time{})
+	if want := expectedMirrorTree(second); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// files and directories keep the mtimes they have in the library, which is when they were last changed
+	mtimes := map[string]time.Time{
+		"d/a.txt": mirrorTestTime,
+		"d":       mirrorTestTime,
+		"e/b.txt": mirrorTestTime.Add(time.Hour),
+		"e":       mirrorTestTime.Add(time.Hour),
+		"c.txt":   mirrorTestTime.Add(time.Hour),
+	}
+	for p, want := range mtimes {
+		info, err := os.Stat(filepath.Join(dest, filepath.FromSlash(p)))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !info.ModTime().Equal(want) {
+			t.Errorf("%s has mtime %v, want %v", p, info.ModTime(), want)
+		}
+	}
+}
+
+func TestExportResume(t *testing.T) {
+	files := map[string]string{"a.txt": "alpha", "d/b.txt": "beta", "d/c.txt": "gamma"}
+	_, commits := buildTestCommits(t, files)
+	sfs, err := commits[0].GetFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// what an interrupted export leaves behind: a complete file, with the mtime from the library, and a partial one,
+	// which was still being written under its temporary name
+	dest := t.TempDir()
+	writeLocalFiles(t, dest, map[string]string{"a.txt": "ALPHA"}, mirrorTestTime)
+	writeLocalFiles(t, dest, map[string]string{"d/b.txt" + exportTempSuffix: "be"}, time.Now())
+	// and one that was written by something else, with the right size but not the right mtime
+	writeLocalFiles(t, dest, map[string]string{"d/c.txt": "GAMMA"}, mirrorTestTime.Add(time.Minute))
+
+	e := runTestExport(t, sfs, dest)
+	if len(e.errors) > 0 {
+		t.Fatalf("export failed: %v", e.errors)
+	}
+	if e.written != 2 || e.skipped != 1 {
+		t.Errorf("wrote %d files and skipped %d, want 2 and 1", e.written, e.skipped)
+	}
+
+	// the complete file is left alone, which is how it can tell that it's already been exported
+	want := expectedMirrorTree(files)
+	want["a.txt"] = "ALPHA"
+	got := readMirrorTree(t, dest, mirrorTestTime)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExportUnreadableFile(t *testing.T) {
+	files := map[string]string{"a.txt": "alpha", "d/b.txt": "beta", "d/c.txt": "gamma"}
+	m, commits := buildTestCommits(t, files)
+	for p, f := range m {
+		if strings.HasPrefix(p, "storage/blocks/") && string(f.Data) == "beta" {
+			delete(m, p)
+		}
+	}
+	sfs, err := commits[0].GetFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest := t.TempDir()
+	e := runTestExport(t, sfs, dest)
+
+	// it's reported, and the other files are still written
+	if len(e.errors) != 1 || !strings.Contains(e.errors[0].Error(), "d/b.txt") || !errors.Is(e.errors[0], fs.ErrNotExist) {
+		t.Errorf("got errors %v, want one for d/b.txt", e.errors)
+	}
+	if e.written != 2 {
+		t.Errorf("wrote %d files, want 2", e.written)
+	}
+
+	// without leaving anything behind in its place
+	want := expectedMirrorTree(files)
+	delete(want, "d/b.txt")
+	got := readMirrorTree(t, dest, mirrorTestTime)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// renameDirents changes the names of the entries of the given directory object, as stored, which doesn't change its
+// ID unless content is verified.
+func renameDirents(t *testing.T, m fstest.MapFS, repoID string, dirID string, names map[string]string) {
+	t.Helper()
+
+	p := path.Join("storage", "fs", repoID, dirID[:2], dirID[2:])
+	z, err := zlib.NewReader(bytes.NewReader(m[p].Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for oldName, newName := range names {
+		old := []byte(`"name":"` + oldName + `"`)
+		if !bytes.Contains(data, old) {
+			t.Fatalf("directory %s has no entry named %s", dirID, oldName)
+		}
+		data = bytes.Replace(data, old, []byte(`"name":"`+newName+`"`), 1)
+	}
+
+	var damaged bytes.Buffer
+	w := zlib.NewWriter(&damaged)
+	w.Write(data)
+	w.Close()
+	m[p] = &fstest.MapFile{Data: damaged.Bytes()}
+}
+
+func TestExportBadNames(t *testing.T) {
+	files := map[string]string{"a.txt": "alpha", "d/b.txt": "beta", "dot.txt": "dot", "x.txt": "x"}
+	m, commits := buildTestCommits(t, files)
+	renameDirents(t, m, commits[0].RepoID, commits[0].RootID, map[string]string{
+		"d":       "../escape",
+		"dot.txt": "..",
+		"x.txt":   "sub/x.txt",
+	})
+	sfs, err := commits[0].GetFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parent := t.TempDir()
+	dest := filepath.Join(parent, "export")
+	err = os.Mkdir(dest, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := runTestExport(t, sfs, dest)
+	if len(e.errors) != 3 {
+		t.Errorf("got errors %v, want one for each bad name", e.errors)
+	}
+	for _, err := range e.errors {
+		if !errors.Is(err, errBadExportName) {
+			t.Errorf("got error %v, want errBadExportName", err)
+		}
+	}
+
+	// nothing is written outside of dest, or under a name the library doesn't have
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("export wrote %d things next to its directory", len(entries)-1)
+	}
+
+	got := readMirrorTree(t, dest, mirrorTestTime)
+	if want := map[string]string{"a.txt": "alpha"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
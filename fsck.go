@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/thatoddmailbox/seafile-browse/config"
+)
+
+func runFsck(args []string, cfg *config.Config) int {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	snapshot := flags.String("snapshot", "", "check the given snapshot instead of the latest data")
+	verbose := flags.Bool("v", false, "list every problem, rather than the first few of each library")
+	verify := flags.Bool("verify", cfg.ShouldVerifyContent(), "read every fs object and block in full, and check its content against its ID")
+	flags.Parse(args)
+
+	storage, err := openStorage(*snapshot, cfg)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	storage.SetVerifyContent(*verify)
+
+	repoIDs, err := storage.ListRepoIDs()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	branchHeads, err := storage.BranchHeads()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	// libraries that the database knows about should have been in storage too
+	for repoID := range branchHeads {
+		found := false
+		for _, existingRepoID := range repoIDs {
+			if existingRepoID == repoID {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			repoIDs = append(repoIDs, repoID)
+		}
+	}
+	sort.Strings(repoIDs)
+
+	healthy := 0
+	for _, repoID := range repoIDs {
+		inf, err := storage.GetRepoInfo(repoID)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+
+		result, err := storage.Check(repoID)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+
+		name := repoID
+		if inf.Name != "" {
+			name = fmt.Sprintf("%s (%s)", inf.Name, repoID)
+		}
+
+		status := "OK"
+		if !result.OK() {
+			status = fmt.Sprintf("%d PROBLEMS", len(result.Problems))
+		} else {
+			healthy++
+		}
+
+		fmt.Printf("%s: %s - %d commits, %d fs objects, %d blocks\n", name, status, result.Commits, result.FSObjects, result.Blocks)
+
+		for i, problem := range result.Problems {
+			if i == 10 && !*verbose {
+				fmt.Printf("\t... and %d more, use -v to see them all\n", len(result.Problems)-i)
+				break
+			}
+
+			fmt.Printf("\t%s\n", problem)
+		}
+	}
+
+	fmt.Printf("\n%d of %d libraries are healthy.\n", healthy, len(repoIDs))
+
+	if healthy != len(repoIDs) {
+		return 1
+	}
+
+	return 0
+}
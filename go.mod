@@ -4,7 +4,11 @@ go 1.17
 
 require (
 	github.com/BurntSushi/toml v1.0.0
+	github.com/go-sql-driver/mysql v1.7.1
 	github.com/thatoddmailbox/fsbrowse v0.1.0
 	github.com/thatoddmailbox/sftpfs v0.1.0
 	golang.org/x/crypto v0.11.0
+	golang.org/x/term v0.10.0
 )
+
+require golang.org/x/sys v0.10.0 // indirect
@@ -1,40 +1,62 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"html"
 	"io/fs"
 	"log"
 	"net/http"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
+	_ "github.com/go-sql-driver/mysql"
 	"github.com/thatoddmailbox/fsbrowse"
 	"github.com/thatoddmailbox/seafile-browse/config"
 	"github.com/thatoddmailbox/seafile-browse/seafile"
 )
 
 type snapshotState struct {
-	repoInfo []seafile.RepoInfo
-	repos    map[string]*seafile.Repo
-	repoFSs  map[string]fs.FS
+	// storage always uses metadata, which is what the state was built from, so that everything in the state agrees
+	storage  *seafile.Storage
+	metadata *seafile.Metadata
+
+	repoInfo  []seafile.RepoInfo
+	repos     map[string]*seafile.Repo
+	repoFSs   map[string]fs.FS
+	encrypted map[string]bool
 }
 
-var allStates map[string]snapshotState = map[string]snapshotState{}
+// snapshotStates holds the state of a single snapshot, which is replaced when its metadata changes.
+type snapshotStates struct {
+	// built is closed once the first state has been built, or has failed to build
+	built chan struct{}
 
-func getStateForSnapshot(snapshot string, cfg *config.Config) ([]seafile.RepoInfo, map[string]*seafile.Repo, map[string]fs.FS) {
-	state, exists := allStates[snapshot]
-	if exists {
-		return state.repoInfo, state.repos, state.repoFSs
-	}
+	// live is the Storage the state is built from, whose metadata is checked for changes
+	live *seafile.Storage
+
+	state      snapshotState
+	rebuilding bool
+}
+
+var allStates map[string]*snapshotStates = map[string]*snapshotStates{}
 
+// stateLock protects allStates, and the fields of each snapshotStates. A snapshotState is not changed once it has been
+// built, so it can be used without the lock, and encrypted repos are unlocked in a session instead.
+var stateLock sync.Mutex
+
+// openStorage opens the Storage for the given snapshot, or the latest data if snapshot is empty.
+func openStorage(snapshot string, cfg *config.Config) (*seafile.Storage, error) {
 	f := cfg.FS()
 	if snapshot != "" {
 		var err error
 		f, err = fs.Sub(cfg.SnapshotFS(), snapshot)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 	}
 
@@ -44,46 +66,209 @@ func getStateForSnapshot(snapshot string, cfg *config.Config) ([]seafile.RepoInf
 	}
 
 	storage := seafile.NewStorageWithFSSubpath(f, path)
+	storage.SetVerifyContent(cfg.ShouldVerifyContent())
+
+	// the live database only describes the latest data, so snapshots still use the files
+	if snapshot == "" && cfg.MySQLDataSource() != "" {
+		db, err := sql.Open("mysql", cfg.MySQLDataSource())
+		if err != nil {
+			return nil, err
+		}
+
+		// fails if the database can't be read, rather than on first use
+		source, err := seafile.NewDBMetadataSource(db, cfg.MySQLRefreshInterval())
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		source.SetRefreshErrorHandler(func(err error) {
+			log.Printf("Could not refresh library metadata, using what was read before: %v", err)
+		})
+
+		storage.SetMetadataSource(source)
+		return storage, nil
+	}
 
 	if cfg.SQLFilePath() != "" {
 		err := storage.ParseSQLFile(cfg.SQLFilePath())
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 	}
 
-	repoIDs, err := storage.ListRepoIDs()
+	if cfg.SQLiteFilePath() != "" {
+		err := storage.ParseSQLiteFile(cfg.SQLiteFilePath())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return storage, nil
+}
+
+// getStateForSnapshot returns the state of the given snapshot, building it the first time. When its metadata has
+// changed, it's built again in the background, and the old state is returned until that has finished.
+func getStateForSnapshot(snapshot string, cfg *config.Config) snapshotState {
+	stateLock.Lock()
+	s, exists := allStates[snapshot]
+	if !exists {
+		s = &snapshotStates{
+			built: make(chan struct{}),
+		}
+		allStates[snapshot] = s
+		stateLock.Unlock()
+
+		return buildFirstState(snapshot, s, cfg)
+	}
+	stateLock.Unlock()
+
+	<-s.built
+
+	stateLock.Lock()
+	live := s.live
+	state := s.state
+	rebuilding := s.rebuilding
+	stateLock.Unlock()
+
+	if state.storage == nil {
+		// building it failed, so try again
+		return getStateForSnapshot(snapshot, cfg)
+	}
+	if rebuilding {
+		return state
+	}
+
+	metadata, err := live.Metadata()
+	if err != nil {
+		log.Printf("Could not refresh library metadata, showing what was read before: %v", err)
+		return state
+	}
+	if metadata == state.metadata {
+		return state
+	}
+
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	if !s.rebuilding {
+		s.rebuilding = true
+		go rebuildState(s)
+	}
+
+	return state
+}
+
+// buildFirstState builds the first state of the given snapshot, which other requests for it wait for.
+func buildFirstState(snapshot string, s *snapshotStates, cfg *config.Config) snapshotState {
+	built := false
+	defer func() {
+		if !built {
+			// forget it, so that the next request tries again
+			stateLock.Lock()
+			delete(allStates, snapshot)
+			stateLock.Unlock()
+		}
+
+		close(s.built)
+	}()
+
+	storage, err := openStorage(snapshot, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	state, err := buildState(storage)
 	if err != nil {
 		panic(err)
 	}
 
+	stateLock.Lock()
+	s.live = storage
+	s.state = state
+	stateLock.Unlock()
+
+	built = true
+	return state
+}
+
+// rebuildState builds the state again from the current metadata, and replaces the old state with it.
+func rebuildState(s *snapshotStates) {
+	state, err := buildState(s.live)
+
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	s.rebuilding = false
+	if err != nil {
+		log.Printf("Could not rebuild library list, showing what was read before: %v", err)
+		return
+	}
+
+	s.state = state
+}
+
+// buildState opens every repo in the given Storage, and reads their latest files. The metadata is read once, so that
+// a refresh during the build can't mix two versions of it.
+func buildState(live *seafile.Storage) (snapshotState, error) {
+	storage, err := live.WithCurrentMetadata()
+	if err != nil {
+		return snapshotState{}, err
+	}
+
+	metadata, err := storage.Metadata()
+	if err != nil {
+		return snapshotState{}, err
+	}
+
+	repoIDs, err := storage.ListRepoIDs()
+	if err != nil {
+		return snapshotState{}, err
+	}
+
 	repos := map[string]*seafile.Repo{}
 	repoFSs := map[string]fs.FS{}
+	encrypted := map[string]bool{}
 	repoInfo := []seafile.RepoInfo{}
 	for _, repoID := range repoIDs {
 		inf, err := storage.GetRepoInfo(repoID)
 		if err != nil {
-			panic(err)
+			return snapshotState{}, err
 		}
 		repoInfo = append(repoInfo, inf)
 
-		repos[repoID], err = storage.OpenRepo(repoID)
-		if err == seafile.ErrGarbageRepo {
-			continue
-		} else if err == seafile.ErrVirtualRepo {
+		repos[repoID], err = openRepoForBrowsing(storage, repoID)
+		if err == seafile.ErrVirtualRepo {
+			log.Printf("Skipping virtual repo %s, its origin is unknown", repoID)
 			continue
 		} else if err != nil {
-			panic(err)
+			return snapshotState{}, err
 		}
 
 		commit, err := repos[repoID].GetLatestCommit()
-		if err != nil {
-			panic(err)
+		if err != nil && inf.Garbage {
+			log.Printf("Skipping deleted repo %s, its data could not be read: %v", repoID, err)
+			continue
+		} else if err != nil {
+			return snapshotState{}, err
+		}
+		if commit == nil {
+			continue
 		}
 
+		encrypted[repoID] = commit.Encrypted
+
 		latestFS, err := commit.GetFS()
-		if err != nil {
-			panic(err)
+		if err == seafile.ErrRepoLocked {
+			// needs a password, which will be asked for when it's opened
+			continue
+		} else if errors.Is(err, fs.ErrNotExist) && inf.Virtual {
+			log.Printf("Skipping virtual repo %s, its data could not be found in %s", repoID, inf.OriginRepoID)
+			continue
+		} else if err != nil && inf.Garbage {
+			log.Printf("Skipping deleted repo %s, its data could not be read: %v", repoID, err)
+			continue
+		} else if err != nil {
+			return snapshotState{}, err
 		}
 
 		repoFSs[repoID] = latestFS
@@ -99,9 +284,61 @@ func getStateForSnapshot(snapshot string, cfg *config.Config) ([]seafile.RepoInf
 		return repoInfo[i].Name < repoInfo[j].Name
 	})
 
-	allStates[snapshot] = snapshotState{repoInfo, repos, repoFSs}
+	return snapshotState{storage, metadata, repoInfo, repos, repoFSs, encrypted}, nil
+}
+
+// openRepoForBrowsing opens the given repo, even if it has been deleted, since its data might still be around.
+func openRepoForBrowsing(storage *seafile.Storage, repoID string) (*seafile.Repo, error) {
+	repo, err := storage.OpenRepo(repoID)
+	if err == seafile.ErrGarbageRepo {
+		repo, err = storage.OpenGarbageRepo(repoID)
+	}
+	return repo, err
+}
+
+// unlockRepo opens a separate copy of the given encrypted repo and tries to unlock it, so that the shared one stays
+// locked for everyone else.
+func unlockRepo(storage *seafile.Storage, repoID string, password string) (*seafile.Repo, error) {
+	repo, err := openRepoForBrowsing(storage, repoID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = repo.Unlock(password)
+	if err != nil {
+		return nil, err
+	}
 
-	return repoInfo, repos, repoFSs
+	return repo, nil
+}
+
+// getUnlockedFS returns the latest data of a repo that was unlocked in the session of the given request, or nil if it
+// hasn't been.
+func getUnlockedFS(r *http.Request, snapshot string, repoID string) (fs.FS, error) {
+	repo := getSession(r).unlockedRepo(snapshot, repoID)
+	if repo == nil {
+		return nil, nil
+	}
+
+	commit, err := repo.GetLatestCommit()
+	if err != nil {
+		return nil, err
+	}
+
+	return commit.GetFS()
+}
+
+func servePasswordPrompt(w http.ResponseWriter, status int, repoInfo seafile.RepoInfo, message string) {
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<html><head><title>seafile-browse</title><body>")
+	fmt.Fprintf(w, "<a href=\"../\">Back to libraries</a><br><br>")
+	fmt.Fprintf(w, "The library %s is encrypted. Enter its password to browse it.<br><br>", html.EscapeString(repoInfo.Name))
+	if message != "" {
+		fmt.Fprintf(w, "%s<br><br>", html.EscapeString(message))
+	}
+	fmt.Fprintf(w, "<form method=\"post\"><input type=\"password\" name=\"password\" autofocus> <input type=\"submit\" value=\"Unlock\"></form>")
+	fmt.Fprintf(w, "</body></html>")
 }
 
 func main() {
@@ -113,6 +350,10 @@ func main() {
 	}
 	defer cfg.Close()
 
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:], cfg))
+	}
+
 	snapshots := []string{}
 	if cfg.HaveSnapshots() {
 		sf := cfg.SnapshotFS()
@@ -155,7 +396,8 @@ func main() {
 			notice += " <a href=\"/snapshots/\">View snapshots</a>"
 		}
 
-		repoInfo, _, repoFSs := getStateForSnapshot(activeSnapshot, cfg)
+		state := getStateForSnapshot(activeSnapshot, cfg)
+		repoInfo, repoFSs, encrypted := state.repoInfo, state.repoFSs, state.encrypted
 
 		if len(pathParts) == 0 || path == "" {
 			// repo list
@@ -166,16 +408,18 @@ func main() {
 			}
 			fmt.Fprintf(w, "Select a library:<ul>")
 			for _, singleRepoInfo := range repoInfo {
-				notOpenable := false
+				_, haveFS := repoFSs[singleRepoInfo.ID]
+				notOpenable := !haveFS && !encrypted[singleRepoInfo.ID]
 
 				suffix := ""
 				if singleRepoInfo.Virtual {
 					suffix += " (virtual)"
-					notOpenable = true
 				}
 				if singleRepoInfo.Garbage {
-					suffix += " (garbage)"
-					notOpenable = true
+					suffix += " (deleted)"
+				}
+				if encrypted[singleRepoInfo.ID] {
+					suffix += " (encrypted)"
 				}
 
 				if notOpenable {
@@ -220,11 +464,62 @@ func main() {
 			return
 		}
 
+		var currentRepoInfo seafile.RepoInfo
+		for _, singleRepoInfo := range repoInfo {
+			if singleRepoInfo.ID == repoID {
+				currentRepoInfo = singleRepoInfo
+			}
+		}
+
 		repoFS, repoExists := repoFSs[repoID]
+		if !repoExists && encrypted[repoID] {
+			unlockedFS, err := getUnlockedFS(r, activeSnapshot, repoID)
+			if err != nil {
+				log.Printf("Could not read unlocked repo %s: %v", repoID, err)
+				servePasswordPrompt(w, http.StatusInternalServerError, currentRepoInfo, "The library could not be read: "+err.Error())
+				return
+			}
+			repoFS, repoExists = unlockedFS, unlockedFS != nil
+		}
+		if !repoExists && encrypted[repoID] {
+			if r.Method != http.MethodPost {
+				servePasswordPrompt(w, http.StatusOK, currentRepoInfo, "")
+				return
+			}
+
+			repo, err := unlockRepo(state.storage, repoID, r.FormValue("password"))
+			if err == seafile.ErrWrongPassword {
+				servePasswordPrompt(w, http.StatusOK, currentRepoInfo, "Incorrect password, please try again.")
+				return
+			} else if err != nil {
+				log.Printf("Could not unlock repo %s: %v", repoID, err)
+				servePasswordPrompt(w, http.StatusInternalServerError, currentRepoInfo, "The library could not be unlocked: "+err.Error())
+				return
+			}
+
+			s, err := startSession(w, r)
+			if err != nil {
+				log.Printf("Could not start session: %v", err)
+				http.Error(w, "Could not start session", http.StatusInternalServerError)
+				return
+			}
+			s.addUnlockedRepo(activeSnapshot, repoID, repo)
+
+			http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+			return
+		}
 		if !repoExists {
 			fmt.Fprintf(w, "Repo ID invalid")
 			return
 		}
+
+		if currentRepoInfo.Garbage {
+			if notice != "" {
+				notice += "<br>"
+			}
+			notice += "<b>This library has been deleted.</b> Its contents are shown read-only, and may be removed by the next garbage collection."
+		}
+
 		// kinda janky, we reuse the request but rewrite its path
 		r.URL.Path = strings.Join(repoPath, "/")
 		fsbrowse.ServeHTTPStateless(w, r, repoFS, "", notice)
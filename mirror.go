@@ -0,0 +1,530 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thatoddmailbox/seafile-browse/config"
+	"github.com/thatoddmailbox/seafile-browse/seafile"
+)
+
+// mirrorRenameSuffix is added to the path of the mirror to get the directory that renamed files pass through, so that
+// two files swapping names don't overwrite each other. It's next to the mirror rather than inside it, so that it can't
+// be mistaken for a file in the library, and on the same filesystem, so that files can be moved into it.
+const mirrorRenameSuffix = ".seafile-mirror-renames"
+
+// mirrorState is what the state file remembers between runs.
+type mirrorState struct {
+	RepoID string `json:"repo_id"`
+
+	// CommitID is the last Commit that was completely mirrored, or empty if there has not been one yet.
+	CommitID string `json:"commit_id"`
+}
+
+func readMirrorState(statePath string) (*mirrorState, error) {
+	data, err := os.ReadFile(statePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	state := mirrorState{}
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", statePath, err)
+	}
+
+	return &state, nil
+}
+
+func writeMirrorState(statePath string, state *mirrorState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tempPath := statePath + exportTempSuffix
+	err = os.WriteFile(tempPath, append(data, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, statePath)
+}
+
+// isEmptyDir returns whether the directory at the given path is empty or does not exist.
+func isEmptyDir(dir string) (bool, error) {
+	f, err := os.Open(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	_, err = f.Readdirnames(1)
+	if err == io.EOF {
+		return true, nil
+	}
+
+	return false, err
+}
+
+type mirror struct {
+	exporter
+
+	// statePath is left alone if it is inside the mirror
+	statePath string
+
+	renameDir string
+
+	removed int
+}
+
+func (m *mirror) remove(p string) error {
+	err := os.RemoveAll(m.localPath(p))
+	if err != nil {
+		return err
+	}
+
+	m.removed++
+	if m.verbose {
+		fmt.Printf("removed %s\n", p)
+	}
+	return nil
+}
+
+// queuePath queues the file at the given path in the library to be written. It's written even if the local file has
+// the same size and mtime, since it's only given when the library says the file changed.
+func (m *mirror) queuePath(p string) error {
+	info, err := fs.Stat(m.fsys, p)
+	if err != nil {
+		m.fail(fmt.Errorf("%s: %w", p, err))
+		return nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(m.localPath(p)), 0755)
+	if err != nil {
+		return err
+	}
+
+	m.write(exportJob{p, info})
+	return nil
+}
+
+// removeTempFiles removes the partly written files that an interrupted run left in the local directory. A full sync
+// removes them along with everything else that isn't in the library, but applying changes only looks at what changed.
+// Files in the library that happen to have the same suffix are left alone.
+func (m *mirror) removeTempFiles() error {
+	return filepath.WalkDir(m.dest, func(localPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(localPath, exportTempSuffix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(m.dest, localPath)
+		if err != nil {
+			return err
+		}
+
+		_, err = fs.Stat(m.fsys, filepath.ToSlash(rel))
+		if !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrInvalid) {
+			return nil
+		}
+
+		return os.Remove(localPath)
+	})
+}
+
+// fullSync makes the local directory match the library, removing anything that is not in it and queueing anything that
+// is missing or different. It returns the directories in the library, parents first.
+func (m *mirror) fullSync() ([]exportJob, error) {
+	err := filepath.WalkDir(m.dest, func(localPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(m.dest, localPath)
+		if err != nil {
+			return err
+		}
+		if rel == "." || localPath == m.statePath {
+			return nil
+		}
+		p := filepath.ToSlash(rel)
+
+		info, err := fs.Stat(m.fsys, p)
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid) || (err == nil && info.IsDir() != d.IsDir()) {
+			err = m.remove(p)
+			if err != nil {
+				return err
+			}
+
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		} else if err != nil {
+			// can't tell whether this should be here, so leave it alone
+			m.fail(fmt.Errorf("%s: %w", p, err))
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m.writeTree(".")
+}
+
+// isInRemovedDir returns whether any of the parents of the given path in the library are in removedDirs.
+func isInRemovedDir(p string, removedDirs map[string]bool) bool {
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if removedDirs[dir] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyChanges updates the local directory with the changes between the last mirrored Commit and the current one,
+// queueing files that need to be written. It returns the directories that were added, parents first.
+func (m *mirror) applyChanges(changes []seafile.Change) ([]exportJob, error) {
+	// left behind if an earlier run was interrupted, in which case the files in it are written again
+	err := os.RemoveAll(m.renameDir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.removeTempFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	// a damaged name could put a change outside of the local directory
+	validChanges := []seafile.Change{}
+	for _, change := range changes {
+		if !fs.ValidPath(change.Path) || (change.OldPath != "" && !fs.ValidPath(change.OldPath)) {
+			m.fail(fmt.Errorf("%q: %w", change.Path, errBadExportName))
+			continue
+		}
+
+		validChanges = append(validChanges, change)
+	}
+	changes = validChanges
+
+	// move renamed files out of the way first, so that deletions and other renames can't affect them
+	renamed := map[int]string{}
+	for i, change := range changes {
+		if change.Type != seafile.ChangeRenamed && change.Type != seafile.ChangeMoved {
+			continue
+		}
+
+		err = os.MkdirAll(m.renameDir, 0755)
+		if err != nil {
+			return nil, err
+		}
+
+		tempPath := filepath.Join(m.renameDir, strconv.Itoa(i))
+		err = os.Rename(m.localPath(change.OldPath), tempPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			// not in the mirror, so it will be written from scratch
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		renamed[i] = tempPath
+	}
+
+	removedDirs := map[string]bool{}
+	for _, change := range changes {
+		if change.Type != seafile.ChangeDeleted {
+			continue
+		}
+
+		// the contents of a deleted directory are listed after it, but were removed along with it
+		if isInRemovedDir(change.Path, removedDirs) {
+			continue
+		}
+		if change.IsDir {
+			removedDirs[change.Path] = true
+		}
+
+		err = m.remove(change.Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dirs := []exportJob{}
+	for i, change := range changes {
+		switch change.Type {
+		case seafile.ChangeRenamed, seafile.ChangeMoved:
+			tempPath, ok := renamed[i]
+			if !ok {
+				if change.IsDir {
+					err = os.MkdirAll(m.localPath(change.Path), 0755)
+					if err != nil {
+						return nil, err
+					}
+
+					var subDirs []exportJob
+					subDirs, err = m.writeTree(change.Path)
+					dirs = append(dirs, subDirs...)
+				} else {
+					err = m.queuePath(change.Path)
+				}
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			err = os.RemoveAll(m.localPath(change.Path))
+			if err != nil {
+				return nil, err
+			}
+
+			err = os.MkdirAll(filepath.Dir(m.localPath(change.Path)), 0755)
+			if err != nil {
+				return nil, err
+			}
+
+			err = os.Rename(tempPath, m.localPath(change.Path))
+			if err != nil {
+				return nil, err
+			}
+
+			if !change.IsDir {
+				info, err := fs.Stat(m.fsys, change.Path)
+				if err == nil {
+					err = os.Chtimes(m.localPath(change.Path), info.ModTime(), info.ModTime())
+				}
+				if err != nil {
+					m.fail(fmt.Errorf("%s: %w", change.Path, err))
+				}
+			}
+
+			if m.verbose {
+				fmt.Printf("%s %s to %s\n", change.Type, change.OldPath, change.Path)
+			}
+
+		case seafile.ChangeAdded, seafile.ChangeModified:
+			if !change.IsDir {
+				err = m.queuePath(change.Path)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			err = os.MkdirAll(m.localPath(change.Path), 0755)
+			if err != nil {
+				return nil, err
+			}
+
+			info, err := fs.Stat(m.fsys, change.Path)
+			if err != nil {
+				m.fail(fmt.Errorf("%s: %w", change.Path, err))
+				continue
+			}
+			dirs = append(dirs, exportJob{change.Path, info})
+		}
+	}
+
+	err = os.RemoveAll(m.renameDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// changes are sorted by path, so parents come first
+	return dirs, nil
+}
+
+func runMirror(args []string, cfg *config.Config) int {
+	flags := flag.NewFlagSet("mirror", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: seafile-browse mirror [flags] <library> <directory>\n\n")
+		fmt.Fprintf(flags.Output(), "Updates the directory to match the latest commit of the library with the given ID or name, only writing\n")
+		fmt.Fprintf(flags.Output(), "what changed since the last time. Anything in the directory that is not in the library is removed.\n\n")
+		flags.PrintDefaults()
+	}
+	snapshot := flags.String("snapshot", "", "mirror from the given snapshot instead of the latest data")
+	statePath := flags.String("state", "", "the file that remembers the last mirrored commit, by default the directory's path with \".seafile-mirror\" added")
+	full := flags.Bool("full", false, "compare every file in the directory with the library, instead of only applying the latest changes")
+	jobCount := flags.Int("j", 4, "how many files to write at once")
+	verbose := flags.Bool("v", false, "print every change as it is made")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 || *jobCount < 1 {
+		flags.Usage()
+		return 2
+	}
+	library := flags.Arg(0)
+	dest, err := filepath.Abs(flags.Arg(1))
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	if *statePath == "" {
+		*statePath = dest + ".seafile-mirror"
+	}
+	*statePath, err = filepath.Abs(*statePath)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	storage, err := openStorage(*snapshot, cfg)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	repo, repoID, err := openRepoForCommand(storage, library)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	state, err := readMirrorState(*statePath)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	if state == nil {
+		// everything in the directory would be removed, so only start with one that has nothing to lose
+		empty, err := isEmptyDir(dest)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		if !empty {
+			log.Printf("%s is not empty, and there is no state file at %s from an earlier mirror of it", dest, *statePath)
+			return 1
+		}
+
+		state = &mirrorState{
+			RepoID: repoID,
+		}
+		err = writeMirrorState(*statePath, state)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+	} else if state.RepoID != repoID {
+		log.Printf("%s is a mirror of library %s, not %s", dest, state.RepoID, repoID)
+		return 1
+	}
+
+	head, err := repo.GetLatestCommit()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	if head == nil {
+		log.Printf("library %s has no commits", repoID)
+		return 1
+	}
+
+	if head.CommitID == state.CommitID && !*full {
+		fmt.Printf("%s is already up to date with commit %s.\n", dest, head.CommitID)
+		return 0
+	}
+
+	sfs, err := head.GetFS()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	var changes []seafile.Change
+	incremental := false
+	if state.CommitID != "" && !*full {
+		var last *seafile.Commit
+		last, err = repo.GetCommit(state.CommitID)
+		if err == nil {
+			changes, err = seafile.Diff(last, head)
+		}
+		if err != nil {
+			log.Printf("could not compare with the last mirrored commit %s, so comparing every file instead: %s", state.CommitID, err)
+		} else {
+			incremental = true
+		}
+	}
+
+	fmt.Printf("Mirroring commit %s (%s) to %s\n", head.CommitID, head.Time().Format(time.RFC1123), dest)
+
+	err = os.MkdirAll(dest, 0755)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	m := mirror{
+		exporter: exporter{
+			fsys:    sfs,
+			dest:    dest,
+			verbose: *verbose,
+		},
+		statePath: *statePath,
+		renameDir: dest + mirrorRenameSuffix,
+	}
+
+	m.start(*jobCount)
+
+	var dirs []exportJob
+	if incremental {
+		dirs, err = m.applyChanges(changes)
+	} else {
+		dirs, err = m.fullSync()
+	}
+
+	m.wait()
+
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	m.setDirTimes(dirs)
+
+	fmt.Printf("Wrote %d files, removed %d.\n", m.written, m.removed)
+
+	if len(m.errors) > 0 {
+		// leave the state file alone, so that the next run tries these again
+		fmt.Printf("%d files or directories could not be read, see above.\n", len(m.errors))
+		return 1
+	}
+
+	state.CommitID = head.CommitID
+	err = writeMirrorState(*statePath, state)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	return 0
+}
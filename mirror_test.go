@@ -0,0 +1,389 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/thatoddmailbox/seafile-browse/seafile"
+	"github.com/thatoddmailbox/seafile-browse/seafile/seafiletest"
+)
+
+var mirrorTestTime = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// buildTestCommits builds a library with the given files in each of its commits, and opens those commits. The files
+// of each commit are an hour newer than those of the one before. The storage is read as it's used, so it can be changed
+// before then.
+func buildTestCommits(t *testing.T, files ...map[string]string) (fstest.MapFS, []*seafile.Commit) {
+	t.Helper()
+
+	lib := seafiletest.Library{Name: "Docs", Owner: "alice@example.com"}
+	for i, f := range files {
+		lib.Commits = append(lib.Commits, seafiletest.Commit{
+			Time:        mirrorTestTime.Add(time.Duration(i) * time.Hour),
+			Description: "Commit",
+			Creator:     "alice@example.com",
+			Files:       f,
+		})
+	}
+	d := &seafiletest.Data{Libraries: []seafiletest.Library{lib}}
+
+	fsys, err := d.MapFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := seafile.NewStorageWithFS(fsys)
+	storage.SetMetadataSource(d.Metadata())
+
+	repo, err := storage.OpenRepo(d.Libraries[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commits := []*seafile.Commit{}
+	for _, c := range d.Libraries[0].Commits {
+		commit, err := repo.GetCommit(c.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits = append(commits, commit)
+	}
+	return fsys, commits
+}
+
+// openMirrorTestCommits builds a library with the given files in each of its commits, and opens those commits.
+func openMirrorTestCommits(t *testing.T, files ...map[string]string) []*seafile.Commit {
+	t.Helper()
+
+	_, commits := buildTestCommits(t, files...)
+	return commits
+}
+
+// applyTestMirror mirrors the given commit to dest, applying the changes from last if it's given, or otherwise comparing
+// every file, and returns the mirror to check what it did.
+func applyTestMirror(t *testing.T, dest string, last *seafile.Commit, head *seafile.Commit) *mirror {
+	t.Helper()
+
+	sfs, err := head.GetFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.MkdirAll(dest, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mirror{
+		exporter: exporter{
+			fsys: sfs,
+			dest: dest,
+		},
+		statePath: filepath.Join(dest, "state"),
+		renameDir: dest + mirrorRenameSuffix,
+	}
+
+	m.start(2)
+
+	var dirs []exportJob
+	if last != nil {
+		var changes []seafile.Change
+		changes, err = seafile.Diff(last, head)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dirs, err = m.applyChanges(changes)
+	} else {
+		dirs, err = m.fullSync()
+	}
+
+	m.wait()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.setDirTimes(dirs)
+
+	_, err = os.Stat(m.renameDir)
+	if err == nil {
+		t.Error("the directory for renames was left behind")
+	}
+
+	return m
+}
+
+// runTestMirror mirrors the given commit to dest like applyTestMirror, and checks that it succeeded. It returns how
+// many files were removed.
+func runTestMirror(t *testing.T, dest string, last *seafile.Commit, head *seafile.Commit) int {
+	t.Helper()
+
+	m := applyTestMirror(t, dest, last, head)
+	if len(m.errors) > 0 {
+		t.Fatalf("mirror failed: %v", m.errors)
+	}
+
+	return m.removed
+}
+
+// readMirrorTree reads the files in dir, with directories given by a trailing slash, and checks that every file has
+// the given mtime.
+func readMirrorTree(t *testing.T, dir string, mtime time.Time) map[string]string {
+	t.Helper()
+
+	result := map[string]string{}
+	err := filepath.WalkDir(dir, func(localPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, localPath)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		p := filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			result[p+"/"] = ""
+			return nil
+		}
+
+		data, err := os.ReadFile(localPath)
+		if err != nil {
+			return err
+		}
+		result[p] = string(data)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !mtime.IsZero() && !info.ModTime().Equal(mtime) {
+			t.Errorf("%s has mtime %v, want %v", p, info.ModTime(), mtime)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// expectedMirrorTree is what readMirrorTree should return for a mirror of a commit with the given files.
+func expectedMirrorTree(files map[string]string) map[string]string {
+	result := map[string]string{}
+	for p, contents := range files {
+		result[p] = contents
+
+		for dir := path.Dir(strings.TrimSuffix(p, "/")); dir != "."; dir = path.Dir(dir) {
+			result[dir+"/"] = ""
+		}
+	}
+	return result
+}
+
+func TestMirrorFullSync(t *testing.T) {
+	files := map[string]string{"a.txt": "a", "d/b.txt": "b", "d/e/": "", "f": "now a file"}
+	commits := openMirrorTestCommits(t, files)
+
+	dest := t.TempDir()
+	for p, contents := range map[string]string{
+		"a.txt":       "old contents",
+		"stray.txt":   "not in the library",
+		"gone/c.txt":  "c",
+		"f/child.txt": "was a directory",
+		"state":       "{}",
+	} {
+		localPath := filepath.Join(dest, filepath.FromSlash(p))
+		err := os.MkdirAll(filepath.Dir(localPath), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(localPath, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed := runTestMirror(t, dest, nil, commits[0])
+	if removed != 3 {
+		t.Errorf("removed %d files or directories, want 3", removed)
+	}
+
+	// the state file is kept
+	want := expectedMirrorTree(files)
+	want["state"] = "{}"
+
+	got := readMirrorTree(t, dest, time.Time{})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMirrorApplyChanges(t *testing.T) {
+	tests := []struct {
+		name        string
+		a, b        map[string]string
+		wantRemoved int
+	}{
+		{
+			name:        "rename plus modify",
+			a:           map[string]string{"a.txt": "alpha", "b.txt": "beta"},
+			b:           map[string]string{"a2.txt": "alpha", "b.txt": "beta, edited", "new/c.txt": "c"},
+			wantRemoved: 0,
+		},
+		{
+			name:        "move directories",
+			a:           map[string]string{"x/d/f.txt": "f", "x/d/g.txt": "g", "y/keep.txt": "k"},
+			b:           map[string]string{"y/d/f.txt": "f", "y/d/g.txt": "g", "y/keep.txt": "k", "x/": ""},
+			wantRemoved: 0,
+		},
+		{
+			// d-x sorts between d and the contents of d, which must still count as removed along with d
+			name:        "delete directories with similar names",
+			a:           map[string]string{"d/f.txt": "f", "d/sub/g.txt": "g", "d-x/h.txt": "h", "keep.txt": "k"},
+			b:           map[string]string{"keep.txt": "k"},
+			wantRemoved: 2,
+		},
+		{
+			// a file in the library with the same name the directory for renames would have had inside the mirror
+			name:        "library file named like the rename directory",
+			a:           map[string]string{mirrorRenameSuffix: "mine", "a.txt": "a"},
+			b:           map[string]string{mirrorRenameSuffix: "mine", "b.txt": "a"},
+			wantRemoved: 0,
+		},
+		{
+			name:        "replace file with directory",
+			a:           map[string]string{"p": "a file", "q/r.txt": "r"},
+			b:           map[string]string{"p/inner.txt": "i", "q": "now a file"},
+			wantRemoved: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			commits := openMirrorTestCommits(t, test.a, test.b)
+			dest := filepath.Join(t.TempDir(), "mirror")
+
+			runTestMirror(t, dest, nil, commits[0])
+			got := readMirrorTree(t, dest, mirrorTestTime)
+			if !reflect.DeepEqual(got, expectedMirrorTree(test.a)) {
+				t.Fatalf("first mirror is %q, want %q", got, expectedMirrorTree(test.a))
+			}
+
+			removed := runTestMirror(t, dest, commits[0], commits[1])
+			if removed != test.wantRemoved {
+				t.Errorf("removed %d files or directories, want %d", removed, test.wantRemoved)
+			}
+
+			got = readMirrorTree(t, dest, time.Time{})
+			want := expectedMirrorTree(test.b)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+
+			// applying the changes gives the same result as comparing everything
+			other := filepath.Join(t.TempDir(), "mirror")
+			runTestMirror(t, other, nil, commits[1])
+			if !reflect.DeepEqual(readMirrorTree(t, other, time.Time{}), got) {
+				t.Errorf("a full mirror gives %q, want %q", readMirrorTree(t, other, time.Time{}), got)
+			}
+		})
+	}
+}
+
+func TestMirrorWritesModifiedFiles(t *testing.T) {
+	commits := openMirrorTestCommits(t,
+		map[string]string{"a.txt": "alpha", "b.txt": "beta"},
+		map[string]string{"a.txt": "ALPHA", "b.txt": "beta"},
+	)
+
+	dest := t.TempDir()
+	runTestMirror(t, dest, nil, commits[0])
+
+	// the local copy happens to have the size and mtime of the new version, but the library says it changed
+	sfs, err := commits[1].GetFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := fs.Stat(sfs, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(filepath.Join(dest, "a.txt"), info.ModTime(), info.ModTime())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runTestMirror(t, dest, commits[0], commits[1])
+
+	got := readMirrorTree(t, dest, time.Time{})
+	want := map[string]string{"a.txt": "ALPHA", "b.txt": "beta"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMirrorRemovesTempFiles(t *testing.T) {
+	commits := openMirrorTestCommits(t,
+		map[string]string{"a.txt": "alpha", "d/kept" + exportTempSuffix: "in the library"},
+		map[string]string{"a.txt": "alpha", "d/kept" + exportTempSuffix: "in the library", "b.txt": "beta"},
+	)
+
+	dest := t.TempDir()
+	runTestMirror(t, dest, nil, commits[0])
+
+	// left behind by an interrupted run, for files that aren't among the next changes
+	writeLocalFiles(t, dest, map[string]string{
+		"a.txt" + exportTempSuffix:      "alp",
+		"d/gone.txt" + exportTempSuffix: "go",
+	}, time.Now())
+
+	runTestMirror(t, dest, commits[0], commits[1])
+
+	got := readMirrorTree(t, dest, time.Time{})
+	want := map[string]string{"a.txt": "alpha", "b.txt": "beta", "d/": "", "d/kept" + exportTempSuffix: "in the library"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMirrorBadNames(t *testing.T) {
+	m, commits := buildTestCommits(t,
+		map[string]string{"a.txt": "alpha"},
+		map[string]string{"a.txt": "alpha", "d/b.txt": "beta"},
+	)
+	renameDirents(t, m, commits[1].RepoID, commits[1].RootID, map[string]string{"d": "../escape"})
+
+	parent := t.TempDir()
+	dest := filepath.Join(parent, "mirror")
+	runTestMirror(t, dest, nil, commits[0])
+
+	mirror := applyTestMirror(t, dest, commits[0], commits[1])
+	if len(mirror.errors) == 0 {
+		t.Error("a change outside of the mirror was not reported")
+	}
+	for _, err := range mirror.errors {
+		if !errors.Is(err, errBadExportName) {
+			t.Errorf("got error %v, want errBadExportName", err)
+		}
+	}
+
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("mirror wrote %d things next to its directory", len(entries)-1)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/thatoddmailbox/seafile-browse/config"
+)
+
+func runOrphans(args []string, cfg *config.Config) int {
+	flags := flag.NewFlagSet("orphans", flag.ExitOnError)
+	snapshot := flags.String("snapshot", "", "report on the given snapshot instead of the latest data")
+	verbose := flags.Bool("v", false, "list the ID of every orphaned object")
+	flags.Parse(args)
+
+	storage, err := openStorage(*snapshot, cfg)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	allOrphans, err := storage.FindOrphans()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	var total int64
+	for _, orphans := range allOrphans {
+		inf, err := storage.GetRepoInfo(orphans.RepoID)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+
+		name := orphans.RepoID
+		if inf.Name != "" {
+			name = fmt.Sprintf("%s (%s)", inf.Name, orphans.RepoID)
+		}
+		if inf.Garbage {
+			name += " (deleted)"
+		}
+
+		fmt.Printf(
+			"%s: %d fs objects (%s), %d blocks (%s)\n",
+			name,
+			len(orphans.FSObjects), formatSize(orphans.FSObjectsSize),
+			len(orphans.Blocks), formatSize(orphans.BlocksSize),
+		)
+
+		if *verbose {
+			for _, id := range orphans.FSObjects {
+				fmt.Printf("\tfs %s\n", id)
+			}
+			for _, id := range orphans.Blocks {
+				fmt.Printf("\tblock %s\n", id)
+			}
+		}
+
+		total += orphans.FSObjectsSize + orphans.BlocksSize
+	}
+
+	fmt.Printf("\nGarbage collection would free %s.\n", formatSize(total))
+
+	return 0
+}
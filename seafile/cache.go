@@ -0,0 +1,182 @@
+package seafile
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultFSCacheSize is the approximate number of bytes of decoded fs objects that a Storage keeps in memory.
+const DefaultFSCacheSize = 64 * 1024 * 1024
+
+// DefaultBlockCacheSize is the number of bytes of verified blocks that a Storage keeps in memory.
+const DefaultBlockCacheSize = 64 * 1024 * 1024
+
+// objectKey identifies an fs object or block. Objects are content-addressed, so an ID always refers to the same data,
+// but they are stored separately for each repo.
+type objectKey struct {
+	repoID string
+	id     string
+}
+
+type fsCacheEntry struct {
+	key  objectKey
+	i    fileInternal
+	size int64
+
+	// verified is set if the object was checked against its ID when it was read
+	verified bool
+}
+
+// fsCache is a least-recently-used cache of decoded fs objects. Cached values are shared, and must not be modified.
+type fsCache struct {
+	lock sync.Mutex
+
+	maxSize int64
+	size    int64
+
+	entries map[objectKey]*list.Element
+	order   *list.List
+}
+
+func newFSCache(maxSize int64) *fsCache {
+	return &fsCache{
+		maxSize: maxSize,
+		entries: map[objectKey]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+// approximateSize estimates how much memory a decoded fs object takes up.
+func approximateSize(i *fileInternal) int64 {
+	size := int64(64)
+	for _, blockID := range i.BlockIDs {
+		size += int64(len(blockID)) + 16
+	}
+	for _, dirent := range i.Dirents {
+		size += int64(len(dirent.ID)+len(dirent.Modifier)+len(dirent.Name)) + 80
+	}
+	return size
+}
+
+// get returns the cached fs object with the given ID. If mustBeVerified is set, an object that wasn't checked against
+// its ID when it was read is treated as not being cached.
+func (c *fsCache) get(repoID string, id string, mustBeVerified bool) (fileInternal, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[objectKey{repoID, id}]
+	if !ok {
+		return fileInternal{}, false
+	}
+
+	entry := element.Value.(*fsCacheEntry)
+	if mustBeVerified && !entry.verified {
+		return fileInternal{}, false
+	}
+
+	c.order.MoveToFront(element)
+	return entry.i, true
+}
+
+func (c *fsCache) put(repoID string, id string, i fileInternal, verified bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := objectKey{repoID, id}
+	if element, exists := c.entries[key]; exists {
+		if verified {
+			element.Value.(*fsCacheEntry).verified = true
+		}
+		return
+	}
+
+	entry := &fsCacheEntry{
+		key:  key,
+		i:    i,
+		size: approximateSize(&i),
+
+		verified: verified,
+	}
+	if entry.size > c.maxSize {
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	c.size += entry.size
+
+	for c.size > c.maxSize {
+		oldest := c.order.Back()
+		oldestEntry := oldest.Value.(*fsCacheEntry)
+
+		c.order.Remove(oldest)
+		delete(c.entries, oldestEntry.key)
+		c.size -= oldestEntry.size
+	}
+}
+
+type blockCacheEntry struct {
+	key  objectKey
+	data []byte
+}
+
+// blockCache is a least-recently-used cache of the contents of blocks that have been checked against their IDs, so
+// that reading the same block again, as every call to File.ReadAt does, doesn't read and hash it again. The checked
+// bytes themselves are kept, rather than just the fact that the block was fine, since reading it from storage again
+// could return something different. Cached contents are shared, and must not be modified.
+type blockCache struct {
+	lock sync.Mutex
+
+	maxSize int64
+	size    int64
+
+	entries map[objectKey]*list.Element
+	order   *list.List
+}
+
+func newBlockCache(maxSize int64) *blockCache {
+	return &blockCache{
+		maxSize: maxSize,
+		entries: map[objectKey]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+// get returns the contents of the block with the given ID, if it's cached.
+func (c *blockCache) get(repoID string, blockID string) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[objectKey{repoID, blockID}]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return element.Value.(*blockCacheEntry).data, true
+}
+
+// put caches the contents of a block, which must already have been checked against its ID.
+func (c *blockCache) put(repoID string, blockID string, data []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := objectKey{repoID, blockID}
+	if _, exists := c.entries[key]; exists {
+		return
+	}
+	if int64(len(data)) > c.maxSize {
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&blockCacheEntry{key, data})
+	c.size += int64(len(data))
+
+	for c.size > c.maxSize {
+		oldest := c.order.Back()
+		oldestEntry := oldest.Value.(*blockCacheEntry)
+
+		c.order.Remove(oldest)
+		delete(c.entries, oldestEntry.key)
+		c.size -= int64(len(oldestEntry.data))
+	}
+}
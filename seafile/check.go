@@ -0,0 +1,331 @@
+package seafile
+
+import (
+	"crypto/aes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+)
+
+// ProblemKind describes something wrong found by Check.
+type ProblemKind int
+
+const (
+	ProblemMissingHead ProblemKind = iota
+	ProblemMissingParent
+	ProblemBadCommit
+	ProblemMissingFSObject
+	ProblemBadFSObject
+	ProblemMissingBlock
+	ProblemTruncatedBlocks
+	ProblemCorruptObject
+)
+
+func (k ProblemKind) String() string {
+	switch k {
+	case ProblemMissingHead:
+		return "missing branch head"
+	case ProblemMissingParent:
+		return "missing parent commit"
+	case ProblemBadCommit:
+		return "unreadable commit"
+	case ProblemMissingFSObject:
+		return "missing fs object"
+	case ProblemBadFSObject:
+		return "unreadable fs object"
+	case ProblemMissingBlock:
+		return "missing block"
+	case ProblemTruncatedBlocks:
+		return "truncated blocks"
+	case ProblemCorruptObject:
+		return "corrupt object"
+	}
+
+	return "unknown problem"
+}
+
+// Problem is a single thing wrong with a Repo, found by Check.
+type Problem struct {
+	Kind ProblemKind
+
+	// ObjectID is the ID of the commit, fs object, or block with the problem.
+	ObjectID string
+
+	// CommitID and Path give where the object was first found, if it was found in a tree.
+	CommitID string
+	Path     string
+
+	Err error
+}
+
+func (p Problem) String() string {
+	result := p.Kind.String() + " " + p.ObjectID
+	if p.Path != "" {
+		result += fmt.Sprintf(" (at %s in commit %s)", p.Path, p.CommitID)
+	} else if p.CommitID != "" && p.CommitID != p.ObjectID {
+		result += fmt.Sprintf(" (from commit %s)", p.CommitID)
+	}
+	if p.Err != nil {
+		result += ": " + p.Err.Error()
+	}
+	return result
+}
+
+// CheckResult describes the health of a Repo, as found by Check.
+type CheckResult struct {
+	RepoID string
+
+	Commits   int
+	FSObjects int
+	Blocks    int
+
+	Problems []Problem
+}
+
+// OK returns whether no problems were found.
+func (r *CheckResult) OK() bool {
+	return len(r.Problems) == 0
+}
+
+type checker struct {
+	s      *Storage
+	r      *Repo
+	result *CheckResult
+
+	commitIDs     map[string]bool
+	fsObjects     map[string]bool
+	blocks        map[string]int64
+	missingBlocks map[string]bool
+}
+
+// Check reads every commit, fs object and block of the Repo with the given ID, and reports anything that is missing
+// or unreadable. It also checks that the Repo's branch head, if known from the MetadataSource, exists.
+//
+// The contents of blocks are not decrypted, but their sizes are checked against the sizes of the files they make up.
+// If content verification is enabled with SetVerifyContent, every fs object and block is also read in full and
+// checked against its ID.
+func (s *Storage) Check(repoID string) (*CheckResult, error) {
+	m, err := s.Metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	// virtual repos keep their own commits, but store everything else with their origin
+	r := newRepo(repoID, s.fsys, s)
+	r.storeID = m.storeID(repoID)
+
+	c := checker{
+		s: s,
+		r: r,
+		result: &CheckResult{
+			RepoID: repoID,
+		},
+
+		commitIDs:     map[string]bool{},
+		fsObjects:     map[string]bool{},
+		blocks:        map[string]int64{},
+		missingBlocks: map[string]bool{},
+	}
+
+	commits, err := c.readCommits(repoID)
+	if err != nil {
+		return nil, err
+	}
+
+	head := m.BranchHeads[repoID]
+	if head != "" && !c.commitIDs[head] {
+		c.problem(Problem{Kind: ProblemMissingHead, ObjectID: head})
+	}
+
+	for _, commit := range commits {
+		for _, parentID := range commit.ParentIDs() {
+			if !c.commitIDs[parentID] {
+				c.problem(Problem{Kind: ProblemMissingParent, ObjectID: parentID, CommitID: commit.CommitID})
+			}
+		}
+
+		c.checkTree(commit, commit.RootID, "/", true)
+	}
+
+	c.result.FSObjects = len(c.fsObjects)
+	c.result.Blocks = len(c.blocks)
+
+	return c.result, nil
+}
+
+func (c *checker) problem(p Problem) {
+	c.result.Problems = append(c.result.Problems, p)
+}
+
+// readCommits reads every commit stored for the repo, oldest first.
+func (c *checker) readCommits(repoID string) ([]*Commit, error) {
+	commits := []*Commit{}
+
+	commitPath := path.Join("storage", "commits", repoID)
+	err := fs.WalkDir(c.s.fsys, commitPath, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && p == commitPath {
+			// no commits at all
+			return fs.SkipDir
+		} else if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		commitID := path.Base(path.Dir(p)) + d.Name()
+		c.commitIDs[commitID] = true
+		c.result.Commits++
+
+		f, err := c.s.fsys.Open(p)
+		if err != nil {
+			c.problem(Problem{Kind: ProblemBadCommit, ObjectID: commitID, Err: err})
+			return nil
+		}
+
+		commit, err := newCommit(c.r, f)
+		if err != nil {
+			c.problem(Problem{Kind: ProblemBadCommit, ObjectID: commitID, Err: err})
+			return nil
+		}
+		if commit.CommitID == "" {
+			commit.CommitID = commitID
+		}
+
+		commits = append(commits, commit)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(commits, func(i, j int) bool {
+		return commits[i].CTime < commits[j].CTime
+	})
+
+	return commits, nil
+}
+
+func (c *checker) checkTree(commit *Commit, id string, p string, isDir bool) {
+	if id == emptyID || c.fsObjects[id] {
+		return
+	}
+	c.fsObjects[id] = true
+
+	i, err := c.r.readFSObject(id)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.problem(Problem{Kind: ProblemMissingFSObject, ObjectID: id, CommitID: commit.CommitID, Path: p})
+		return
+	} else if errors.Is(err, ErrCorruptObject) {
+		c.problem(Problem{Kind: ProblemCorruptObject, ObjectID: id, CommitID: commit.CommitID, Path: p})
+		return
+	} else if err != nil {
+		c.problem(Problem{Kind: ProblemBadFSObject, ObjectID: id, CommitID: commit.CommitID, Path: p, Err: err})
+		return
+	}
+
+	if isDir {
+		for _, dirent := range i.Dirents {
+			c.checkTree(commit, dirent.ID, path.Join(p, dirent.Name), direntIsDir(&dirent))
+		}
+		return
+	}
+
+	var totalSize int64
+	truncatedBlock := false
+	for _, blockID := range i.BlockIDs {
+		if c.missingBlocks[blockID] {
+			// already reported
+			return
+		}
+
+		size, err := c.blockSize(blockID)
+		if errors.Is(err, ErrCorruptObject) {
+			c.missingBlocks[blockID] = true
+			c.problem(Problem{Kind: ProblemCorruptObject, ObjectID: blockID, CommitID: commit.CommitID, Path: p})
+			return
+		} else if err != nil {
+			c.missingBlocks[blockID] = true
+
+			if errors.Is(err, fs.ErrNotExist) {
+				err = nil
+			}
+			c.problem(Problem{Kind: ProblemMissingBlock, ObjectID: blockID, CommitID: commit.CommitID, Path: p, Err: err})
+			return
+		}
+
+		totalSize += size
+		if commit.Encrypted && (size == 0 || size%aes.BlockSize != 0) {
+			// can't be a whole encrypted block
+			truncatedBlock = true
+		}
+	}
+
+	expectedSize := i.Size
+	if commit.Encrypted && !truncatedBlock {
+		// each block is padded by between 1 and 16 bytes when encrypted
+		padding := totalSize - expectedSize
+		if padding >= int64(len(i.BlockIDs)) && padding <= aes.BlockSize*int64(len(i.BlockIDs)) {
+			return
+		}
+	} else if !commit.Encrypted && totalSize == expectedSize {
+		return
+	}
+
+	c.problem(Problem{
+		Kind:     ProblemTruncatedBlocks,
+		ObjectID: id,
+		CommitID: commit.CommitID,
+		Path:     p,
+		Err:      fmt.Errorf("blocks contain %d bytes, file should have %d", totalSize, expectedSize),
+	})
+}
+
+func (c *checker) blockSize(blockID string) (int64, error) {
+	size, checked := c.blocks[blockID]
+	if checked {
+		return size, nil
+	}
+
+	blockPath := path.Join("storage", "blocks", c.r.storeID, blockID[:2], blockID[2:])
+
+	if c.s.verifyContent {
+		f, err := c.s.fsys.Open(blockPath)
+		if err != nil {
+			return 0, err
+		}
+
+		data, err := verifyBlock(f, c.r.storeID, blockID)
+		if err != nil {
+			return 0, err
+		}
+
+		c.blocks[blockID] = int64(len(data))
+		return int64(len(data)), nil
+	}
+
+	info, err := fs.Stat(c.s.fsys, blockPath)
+	if err != nil {
+		return 0, err
+	}
+
+	c.blocks[blockID] = info.Size()
+	return info.Size(), nil
+}
+
+// BranchHeads returns the latest commit of each repo, as given by the MetadataSource. It is empty if there is none.
+func (s *Storage) BranchHeads() (map[string]string, error) {
+	m, err := s.Metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]string{}
+	for repoID, commitID := range m.BranchHeads {
+		result[repoID] = commitID
+	}
+	return result, nil
+}
@@ -0,0 +1,311 @@
+package seafile_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/thatoddmailbox/seafile-browse/seafile"
+	"github.com/thatoddmailbox/seafile-browse/seafile/seafiletest"
+)
+
+// buildTestFS builds the given data in memory, without opening it, so that tests can damage it first.
+func buildTestFS(t *testing.T, d *seafiletest.Data) fstest.MapFS {
+	t.Helper()
+
+	m, err := d.MapFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// storedObjects returns the paths of every object of the given kind stored under the given repo, sorted.
+func storedObjects(m fstest.MapFS, kind string, repoID string) []string {
+	prefix := path.Join("storage", kind, repoID) + "/"
+
+	result := []string{}
+	for name := range m {
+		if strings.HasPrefix(name, prefix) {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// objectID turns the path of a stored object back into its ID.
+func objectID(p string) string {
+	return path.Base(path.Dir(p)) + path.Base(p)
+}
+
+// fileObjects returns the IDs of the blocks of each fs object stored under the given repo that describes a file, by
+// the path of the object.
+func fileObjects(t *testing.T, m fstest.MapFS, repoID string) map[string][]string {
+	t.Helper()
+
+	result := map[string][]string{}
+	for _, p := range storedObjects(m, "fs", repoID) {
+		z, err := zlib.NewReader(bytes.NewReader(m[p].Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(z)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var object struct {
+			BlockIDs []string `json:"block_ids"`
+			Type     int      `json:"type"`
+		}
+		err = json.Unmarshal(data, &object)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if object.Type == 1 {
+			result[p] = object.BlockIDs
+		}
+	}
+	return result
+}
+
+// someFileObject returns the path of one of the fs objects describing a file stored under the given repo, and the
+// path of its first block.
+func someFileObject(t *testing.T, m fstest.MapFS, repoID string) (string, string) {
+	t.Helper()
+
+	objects := fileObjects(t, m, repoID)
+	paths := []string{}
+	for p := range objects {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	blockID := objects[paths[0]][0]
+	return paths[0], path.Join("storage", "blocks", repoID, blockID[:2], blockID[2:])
+}
+
+func checkProblems(t *testing.T, s *seafile.Storage, repoID string) []seafile.Problem {
+	t.Helper()
+
+	result, err := s.Check(repoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.Problems
+}
+
+func TestCheckHealthy(t *testing.T) {
+	d := &seafiletest.Data{
+		BlockSize: 4,
+		Libraries: []seafiletest.Library{
+			{
+				Name: "Docs",
+				Commits: []seafiletest.Commit{
+					commitAt(0, map[string]string{"a.txt": "12345678", "d/b.txt": "b", "empty.txt": ""}),
+					commitAt(1, map[string]string{"a.txt": "12345678", "d/b.txt": "b2", "empty.txt": ""}),
+				},
+			},
+			{
+				Name:       "Secret",
+				Password:   "hunter2",
+				EncVersion: 2,
+				Commits:    []seafiletest.Commit{commitAt(0, map[string]string{"a.txt": "123456789"})},
+			},
+		},
+	}
+	m := buildTestFS(t, d)
+
+	for _, verify := range []bool{false, true} {
+		s := seafile.NewStorageWithFS(m)
+		s.SetMetadataSource(d.Metadata())
+		s.SetVerifyContent(verify)
+
+		for _, lib := range d.Libraries {
+			result, err := s.Check(lib.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.OK() {
+				t.Errorf("%s (verify %v): found problems %v", lib.Name, verify, result.Problems)
+			}
+			if result.Commits != len(lib.Commits) {
+				t.Errorf("%s: counted %d commits, want %d", lib.Name, result.Commits, len(lib.Commits))
+			}
+			if result.FSObjects != len(storedObjects(m, "fs", lib.ID)) {
+				t.Errorf("%s: counted %d fs objects, want %d", lib.Name, result.FSObjects, len(storedObjects(m, "fs", lib.ID)))
+			}
+			if result.Blocks != len(storedObjects(m, "blocks", lib.ID)) {
+				t.Errorf("%s: counted %d blocks, want %d", lib.Name, result.Blocks, len(storedObjects(m, "blocks", lib.ID)))
+			}
+		}
+	}
+}
+
+func TestCheckProblems(t *testing.T) {
+	d := &seafiletest.Data{
+		Libraries: []seafiletest.Library{
+			{
+				Name: "Docs",
+				Commits: []seafiletest.Commit{
+					commitAt(0, map[string]string{"d/a.txt": "first"}),
+					commitAt(1, map[string]string{"d/a.txt": "second"}),
+				},
+			},
+			{
+				Name:       "Secret",
+				Password:   "hunter2",
+				EncVersion: 3,
+				Commits:    []seafiletest.Commit{commitAt(0, map[string]string{"d/a.txt": "first"})},
+			},
+		},
+	}
+	m := buildTestFS(t, d)
+	docs := d.Libraries[0]
+	secret := d.Libraries[1]
+
+	tests := []struct {
+		name   string
+		repoID string
+		damage func(t *testing.T, m fstest.MapFS) string
+		verify bool
+		want   seafile.ProblemKind
+
+		// inTree is set for problems found while walking a tree, which are all with d/a.txt here
+		inTree bool
+	}{
+		{
+			name:   "missing block",
+			repoID: docs.ID,
+			damage: func(t *testing.T, m fstest.MapFS) string {
+				p := storedObjects(m, "blocks", docs.ID)[0]
+				delete(m, p)
+				return objectID(p)
+			},
+			want:   seafile.ProblemMissingBlock,
+			inTree: true,
+		},
+		{
+			name:   "truncated block",
+			repoID: docs.ID,
+			damage: func(t *testing.T, m fstest.MapFS) string {
+				fileObject, block := someFileObject(t, m, docs.ID)
+				m[block] = &fstest.MapFile{Data: m[block].Data[:2]}
+				// reported for the file made of the block
+				return objectID(fileObject)
+			},
+			want:   seafile.ProblemTruncatedBlocks,
+			inTree: true,
+		},
+		{
+			name:   "missing fs object",
+			repoID: docs.ID,
+			damage: func(t *testing.T, m fstest.MapFS) string {
+				p, _ := someFileObject(t, m, docs.ID)
+				delete(m, p)
+				return objectID(p)
+			},
+			want:   seafile.ProblemMissingFSObject,
+			inTree: true,
+		},
+		{
+			name:   "unreadable fs object",
+			repoID: docs.ID,
+			damage: func(t *testing.T, m fstest.MapFS) string {
+				p, _ := someFileObject(t, m, docs.ID)
+				m[p] = &fstest.MapFile{Data: []byte("not zlib")}
+				return objectID(p)
+			},
+			want:   seafile.ProblemBadFSObject,
+			inTree: true,
+		},
+		{
+			name:   "corrupt block",
+			repoID: docs.ID,
+			damage: func(t *testing.T, m fstest.MapFS) string {
+				p := storedObjects(m, "blocks", docs.ID)[0]
+				data := append([]byte{}, m[p].Data...)
+				data[0] ^= 1
+				m[p] = &fstest.MapFile{Data: data}
+				return objectID(p)
+			},
+			verify: true,
+			want:   seafile.ProblemCorruptObject,
+			inTree: true,
+		},
+		{
+			name:   "missing parent",
+			repoID: docs.ID,
+			damage: func(t *testing.T, m fstest.MapFS) string {
+				p := path.Join("storage", "commits", docs.ID, docs.Commits[0].ID[:2], docs.Commits[0].ID[2:])
+				delete(m, p)
+				return docs.Commits[0].ID
+			},
+			want: seafile.ProblemMissingParent,
+		},
+		{
+			name:   "unreadable commit",
+			repoID: docs.ID,
+			damage: func(t *testing.T, m fstest.MapFS) string {
+				p := path.Join("storage", "commits", docs.ID, docs.Commits[0].ID[:2], docs.Commits[0].ID[2:])
+				m[p] = &fstest.MapFile{Data: []byte("{")}
+				return docs.Commits[0].ID
+			},
+			want: seafile.ProblemBadCommit,
+		},
+		{
+			name:   "missing head",
+			repoID: docs.ID,
+			damage: func(t *testing.T, m fstest.MapFS) string {
+				p := path.Join("storage", "commits", docs.ID, docs.Commits[1].ID[:2], docs.Commits[1].ID[2:])
+				delete(m, p)
+				return docs.Commits[1].ID
+			},
+			want: seafile.ProblemMissingHead,
+		},
+		{
+			// an encrypted block that isn't a whole number of AES blocks
+			name:   "truncated encrypted block",
+			repoID: secret.ID,
+			damage: func(t *testing.T, m fstest.MapFS) string {
+				fileObject, block := someFileObject(t, m, secret.ID)
+				m[block] = &fstest.MapFile{Data: m[block].Data[:len(m[block].Data)-1]}
+				return objectID(fileObject)
+			},
+			want:   seafile.ProblemTruncatedBlocks,
+			inTree: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			damaged := fstest.MapFS{}
+			for name, file := range m {
+				damaged[name] = file
+			}
+			objectID := test.damage(t, damaged)
+
+			s := seafile.NewStorageWithFS(damaged)
+			s.SetMetadataSource(d.Metadata())
+			s.SetVerifyContent(test.verify)
+
+			problems := checkProblems(t, s, test.repoID)
+			if len(problems) != 1 {
+				t.Fatalf("found problems %v, want one %s", problems, test.want)
+			}
+			if problems[0].Kind != test.want || problems[0].ObjectID != objectID {
+				t.Errorf("found %v, want %s %s", problems[0], test.want, objectID)
+			}
+			if test.inTree && (problems[0].Path != "/d/a.txt" || problems[0].CommitID == "") {
+				t.Errorf("found %v at %s in commit %q, want it at /d/a.txt", problems[0], problems[0].Path, problems[0].CommitID)
+			}
+		})
+	}
+}
@@ -4,28 +4,114 @@ import (
 	"bufio"
 	"encoding/json"
 	"io/fs"
+	"time"
 )
 
 type Commit struct {
-	repoID string
-	fsys   fs.FS
-
-	CommitID    string `json:"commit_id"`
-	RootID      string `json:"root_id"`
-	Description string `json:"description"`
-	CTime       uint64 `json:"ctime"`
-	ParentID    string `json:"parent_id"`
+	repo *Repo
+	fsys fs.FS
+
+	CommitID       string `json:"commit_id"`
+	RootID         string `json:"root_id"`
+	RepoID         string `json:"repo_id"`
+	Description    string `json:"description"`
+	CTime          uint64 `json:"ctime"`
+	ParentID       string `json:"parent_id"`
+	SecondParentID string `json:"second_parent_id"`
+
+	// Creator is the ID of the user that made the Commit, and CreatorName is usually their email address.
+	Creator     string `json:"creator"`
+	CreatorName string `json:"creator_name"`
+
+	// DeviceName and ClientVersion describe the client that made the Commit. They are empty for Commits made through
+	// the web interface.
+	DeviceName    string `json:"device_name"`
+	ClientVersion string `json:"client_version"`
+
+	RepoName     string `json:"repo_name"`
+	RepoDesc     string `json:"repo_desc"`
+	RepoCategory string `json:"repo_category"`
+
+	// Version is the storage format version of the Repo. Libraries created by very old versions of Seafile use 0.
+	Version        int  `json:"version"`
+	NoLocalHistory bool `json:"no_local_history"`
+
+	// Encrypted is set for password-protected libraries, which store the rest of their key information here.
+	Encrypted  bool   `json:"encrypted"`
+	EncVersion int    `json:"enc_version"`
+	Magic      string `json:"magic"`
+	RandomKey  string `json:"key"`
+	Salt       string `json:"salt"`
+}
+
+// UnmarshalJSON decodes a commit object. Seafile stores some of its flags as the string "true" or the number 1, so
+// those are handled here.
+func (c *Commit) UnmarshalJSON(data []byte) error {
+	type commitAlias Commit
+	aux := struct {
+		*commitAlias
+		NoLocalHistory interface{} `json:"no_local_history"`
+		Encrypted      interface{} `json:"encrypted"`
+	}{
+		commitAlias: (*commitAlias)(c),
+	}
+
+	err := json.Unmarshal(data, &aux)
+	if err != nil {
+		return err
+	}
+
+	c.NoLocalHistory = parseJSONFlag(aux.NoLocalHistory)
+	c.Encrypted = parseJSONFlag(aux.Encrypted)
+
+	return nil
 }
 
 // GetFS returns an FS of the Repo's state at the given Commit.
+// If the Repo is encrypted, it must be unlocked first.
 func (c *Commit) GetFS() (*FS, error) {
+	if c.Encrypted && c.repo.crypt == nil {
+		return nil, ErrRepoLocked
+	}
+
 	return newFS(c)
 }
 
-func newCommit(repoID string, fsys fs.FS, f fs.File) (*Commit, error) {
+// Time returns the time at which the Commit was made.
+func (c *Commit) Time() time.Time {
+	return time.Unix(int64(c.CTime), 0)
+}
+
+// ParentIDs returns the IDs of the Commit's parents. It is empty for the first Commit to a Repo, and has two entries
+// for a merge.
+func (c *Commit) ParentIDs() []string {
+	result := []string{}
+	if c.ParentID != "" {
+		result = append(result, c.ParentID)
+	}
+	if c.SecondParentID != "" {
+		result = append(result, c.SecondParentID)
+	}
+	return result
+}
+
+func parseJSONFlag(v interface{}) bool {
+	switch value := v.(type) {
+	case bool:
+		return value
+	case float64:
+		return value != 0
+	case string:
+		return value == "true" || value == "1"
+	}
+
+	return false
+}
+
+func newCommit(r *Repo, f fs.File) (*Commit, error) {
 	c := Commit{
-		repoID: repoID,
-		fsys:   fsys,
+		repo: r,
+		fsys: r.fsys,
 	}
 
 	defer f.Close()
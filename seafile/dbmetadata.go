@@ -0,0 +1,198 @@
+package seafile
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DBMetadataSource is a MetadataSource that queries a running seafile_db database, such as Seafile's MySQL server.
+// The database is queried again in the background on an interval, and Metadata always returns the result of the last
+// query that succeeded, so a database that is slow or unreachable never holds up its callers.
+type DBMetadataSource struct {
+	db              *sql.DB
+	refreshInterval time.Duration
+
+	lock         sync.Mutex
+	metadata     *Metadata
+	errorHandler func(err error)
+
+	// cancel stops the background refresh, and any query it's making, and done is closed once it has stopped
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewDBMetadataSource creates a DBMetadataSource that reads from the given database, which can use any database/sql
+// driver. The database is queried once before it returns, and the error is returned if that fails. After that, it's
+// queried again every refreshInterval until Close is called, and each query is given up on if it takes longer than
+// refreshInterval. If refreshInterval is not positive, the database is only queried once. The database is not closed
+// by the source.
+func NewDBMetadataSource(db *sql.DB, refreshInterval time.Duration) (*DBMetadataSource, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &DBMetadataSource{
+		db:              db,
+		refreshInterval: refreshInterval,
+
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	m, err := d.query(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	d.metadata = m
+
+	if refreshInterval > 0 {
+		go d.refreshLoop(ctx)
+	} else {
+		close(d.done)
+	}
+
+	return d, nil
+}
+
+// Metadata returns the metadata from the last query of the database that succeeded. It never returns an error.
+func (d *DBMetadataSource) Metadata() (*Metadata, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.metadata, nil
+}
+
+// SetRefreshErrorHandler sets a function that is called with the error whenever a refresh fails, from the goroutine
+// that does the refreshing. The metadata from before is kept when that happens.
+func (d *DBMetadataSource) SetRefreshErrorHandler(fn func(err error)) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.errorHandler = fn
+}
+
+// Close stops refreshing the metadata, and waits for a query that is in progress to be given up on.
+func (d *DBMetadataSource) Close() error {
+	d.cancel()
+	<-d.done
+	return nil
+}
+
+func (d *DBMetadataSource) refreshLoop(ctx context.Context) {
+	defer close(d.done)
+
+	ticker := time.NewTicker(d.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		m, err := d.query(ctx)
+		if ctx.Err() != nil {
+			// closed while querying
+			return
+		}
+
+		d.lock.Lock()
+		handler := d.errorHandler
+		if err == nil && !reflect.DeepEqual(m, d.metadata) {
+			// only replaced when something changed, so that callers can tell by comparing pointers
+			d.metadata = m
+		}
+		d.lock.Unlock()
+
+		if err != nil && handler != nil {
+			handler(err)
+		}
+	}
+}
+
+// query reads every table in seafileTableColumns, inside a single read-only transaction so that they agree with each
+// other. Shares are sorted, since the order the database returns rows in can change.
+func (d *DBMetadataSource) query(ctx context.Context) (*Metadata, error) {
+	if d.refreshInterval > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.refreshInterval)
+		defer cancel()
+	}
+
+	tx, err := d.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	tables := []string{}
+	for table := range seafileTableColumns {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	m := newMetadata()
+	for _, table := range tables {
+		err = queryTable(ctx, tx, table, m.addRow)
+		if err != nil {
+			return nil, fmt.Errorf("seafile: reading %s: %w", table, err)
+		}
+	}
+
+	sort.SliceStable(m.Shares, func(i, j int) bool {
+		a, b := m.Shares[i], m.Shares[j]
+		if a.RepoID != b.RepoID {
+			return a.RepoID < b.RepoID
+		}
+		if a.ToUser != b.ToUser {
+			return a.ToUser < b.ToUser
+		}
+		return a.ToGroupID < b.ToGroupID
+	})
+
+	return m, nil
+}
+
+// queryTable calls fn with every row of the given table. Columns are matched by name, so any that Seafile adds or
+// removes between versions don't matter.
+func queryTable(ctx context.Context, tx *sql.Tx, table string, fn func(table string, row sqlRow) error) error {
+	rows, err := tx.QueryContext(ctx, "SELECT * FROM "+table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	for rows.Next() {
+		err = rows.Scan(dest...)
+		if err != nil {
+			return err
+		}
+
+		row := sqlRow{}
+		for i, column := range columns {
+			row[column] = sqlValue{text: values[i].String, null: !values[i].Valid}
+		}
+
+		err = fn(table, row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,327 @@
+package seafile_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thatoddmailbox/seafile-browse/seafile"
+)
+
+// stubDB is a database/sql driver that serves "SELECT * FROM <table>" from tables held in memory, and can be made to
+// fail, or to stop answering, standing in for Seafile's MySQL server.
+type stubDB struct {
+	lock    sync.Mutex
+	tables  map[string]stubTable
+	fail    bool
+	hang    bool
+	queries int
+}
+
+type stubTable struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+var errStubDBDown = errors.New("stub database is down")
+
+func (db *stubDB) set(tables map[string]stubTable, fail bool, hang bool) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.tables = tables
+	db.fail = fail
+	db.hang = hang
+}
+
+func (db *stubDB) queryCount() int {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.queries
+}
+
+func (db *stubDB) Connect(ctx context.Context) (driver.Conn, error) {
+	return stubConn{db}, nil
+}
+
+func (db *stubDB) Driver() driver.Driver {
+	return nil
+}
+
+type stubConn struct {
+	db *stubDB
+}
+
+func (c stubConn) Prepare(query string) (driver.Stmt, error) {
+	table := strings.TrimPrefix(query, "SELECT * FROM ")
+	if table == query {
+		return nil, errors.New("unexpected query " + query)
+	}
+
+	return stubStmt{c.db, table}, nil
+}
+
+func (c stubConn) Close() error {
+	return nil
+}
+
+func (c stubConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c stubConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.db.lock.Lock()
+	defer c.db.lock.Unlock()
+
+	c.db.queries++
+	if c.db.fail {
+		return nil, errStubDBDown
+	}
+	if c.db.hang {
+		c.db.lock.Unlock()
+		<-ctx.Done()
+		c.db.lock.Lock()
+		return nil, ctx.Err()
+	}
+
+	return stubTx{}, nil
+}
+
+type stubTx struct{}
+
+func (stubTx) Commit() error {
+	return nil
+}
+
+func (stubTx) Rollback() error {
+	return nil
+}
+
+type stubStmt struct {
+	db    *stubDB
+	table string
+}
+
+func (s stubStmt) Close() error {
+	return nil
+}
+
+func (s stubStmt) NumInput() int {
+	return 0
+}
+
+func (s stubStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s stubStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.lock.Lock()
+	defer s.db.lock.Unlock()
+
+	table := s.db.tables[s.table]
+	return &stubRows{table: table}, nil
+}
+
+type stubRows struct {
+	table stubTable
+	next  int
+}
+
+func (r *stubRows) Columns() []string {
+	return r.table.columns
+}
+
+func (r *stubRows) Close() error {
+	return nil
+}
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.table.rows) {
+		return io.EOF
+	}
+
+	copy(dest, r.table.rows[r.next])
+	r.next++
+	return nil
+}
+
+// stubTables returns tables describing a single repo with the given name and head. If reversed is set, the rows of
+// each table are returned in the opposite order.
+func stubTables(name string, head string, reversed bool) map[string]stubTable {
+	const repoID = "11111111-1111-4111-8111-111111111111"
+	tables := map[string]stubTable{
+		"Branch": {
+			columns: []string{"id", "name", "repo_id", "commit_id"},
+			rows:    [][]driver.Value{{int64(1), "master", repoID, head}},
+		},
+		"RepoInfo": {
+			// columns Seafile doesn't have are ignored
+			columns: []string{"id", "repo_id", "name", "update_time", "extra"},
+			rows:    [][]driver.Value{{int64(1), repoID, name, int64(1622548800), nil}},
+		},
+		"RepoOwner": {
+			columns: []string{"id", "repo_id", "owner_id"},
+			rows:    [][]driver.Value{{int64(1), repoID, "alice@example.com"}},
+		},
+		"SharedRepo": {
+			columns: []string{"id", "repo_id", "from_email", "to_email", "permission"},
+			rows: [][]driver.Value{
+				{int64(1), repoID, "alice@example.com", "bob@example.com", "r"},
+				{int64(2), repoID, "alice@example.com", "carol@example.com", "rw"},
+			},
+		},
+		"RepoGroup": {
+			columns: []string{"id", "repo_id", "group_id", "user_name", "permission"},
+			rows:    [][]driver.Value{{int64(1), repoID, int64(7), "alice@example.com", "rw"}},
+		},
+	}
+
+	if reversed {
+		for _, table := range tables {
+			for i, j := 0, len(table.rows)-1; i < j; i, j = i+1, j-1 {
+				table.rows[i], table.rows[j] = table.rows[j], table.rows[i]
+			}
+		}
+	}
+
+	return tables
+}
+
+func checkStubMetadata(t *testing.T, m *seafile.Metadata, name string, head string) {
+	t.Helper()
+
+	const repoID = "11111111-1111-4111-8111-111111111111"
+	want := seafile.RepoMetadata{Name: name, Owner: "alice@example.com"}
+	if m.Repos[repoID] != want {
+		t.Errorf("repo is %+v, want %+v", m.Repos[repoID], want)
+	}
+	if m.BranchHeads[repoID] != head {
+		t.Errorf("head is %q, want %q", m.BranchHeads[repoID], head)
+	}
+	wantShares := []seafile.Share{
+		{RepoID: repoID, From: "alice@example.com", ToGroupID: "7", Permission: "rw"},
+		{RepoID: repoID, From: "alice@example.com", ToUser: "bob@example.com", Permission: "r"},
+		{RepoID: repoID, From: "alice@example.com", ToUser: "carol@example.com", Permission: "rw"},
+	}
+	if !reflect.DeepEqual(m.Shares, wantShares) {
+		t.Errorf("shares are %+v, want %+v", m.Shares, wantShares)
+	}
+}
+
+// waitFor waits for cond to be true, failing if it takes too long.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDBMetadataSourceFirstQueryFails(t *testing.T) {
+	stub := &stubDB{}
+	stub.set(stubTables("Docs", "a", false), true, false)
+	db := sql.OpenDB(stub)
+	defer db.Close()
+
+	source, err := seafile.NewDBMetadataSource(db, time.Hour)
+	if !errors.Is(err, errStubDBDown) || source != nil {
+		t.Fatalf("NewDBMetadataSource returned %v, %v, want nil and the database's error", source, err)
+	}
+}
+
+func TestDBMetadataSourceRefresh(t *testing.T) {
+	const interval = 10 * time.Millisecond
+
+	stub := &stubDB{}
+	stub.set(stubTables("Docs", "a", false), false, false)
+	db := sql.OpenDB(stub)
+	defer db.Close()
+
+	source, err := seafile.NewDBMetadataSource(db, interval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+
+	var errorsLock sync.Mutex
+	refreshErrors := []error{}
+	source.SetRefreshErrorHandler(func(err error) {
+		errorsLock.Lock()
+		defer errorsLock.Unlock()
+
+		refreshErrors = append(refreshErrors, err)
+	})
+	lastRefreshError := func() error {
+		errorsLock.Lock()
+		defer errorsLock.Unlock()
+
+		if len(refreshErrors) == 0 {
+			return nil
+		}
+		return refreshErrors[len(refreshErrors)-1]
+	}
+
+	good, err := source.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStubMetadata(t, good, "Docs", "a")
+
+	// refreshes that find nothing changed, even with rows in a different order, keep the same Metadata
+	stub.set(stubTables("Docs", "a", true), false, false)
+	queries := stub.queryCount()
+	waitFor(t, "refreshes", func() bool { return stub.queryCount() >= queries+3 })
+	if m, _ := source.Metadata(); m != good {
+		t.Error("Metadata() changed without the database changing")
+	}
+
+	// a failed refresh is reported, and the last good metadata is kept
+	stub.set(stubTables("Docs", "a", false), true, false)
+	waitFor(t, "a failed refresh", func() bool { return errors.Is(lastRefreshError(), errStubDBDown) })
+	if m, _ := source.Metadata(); m != good {
+		t.Error("Metadata() did not return the last good metadata after a failed refresh")
+	}
+
+	// as is a database that stops answering, which doesn't hold up Metadata while it's being queried
+	stub.set(stubTables("Docs", "a", false), false, true)
+	queries = stub.queryCount()
+	waitFor(t, "a query to start", func() bool { return stub.queryCount() > queries })
+	start := time.Now()
+	m, err := source.Metadata()
+	if err != nil || m != good {
+		t.Errorf("Metadata() returned %p, %v while the database wasn't answering, want the last good metadata", m, err)
+	}
+	if elapsed := time.Since(start); elapsed > interval/2 {
+		t.Errorf("Metadata() took %v while the database wasn't answering", elapsed)
+	}
+	waitFor(t, "a query to time out", func() bool { return errors.Is(lastRefreshError(), context.DeadlineExceeded) })
+
+	// and once the database is back, its changes are picked up
+	stub.set(stubTables("Renamed", "b", false), false, false)
+	waitFor(t, "the change to be picked up", func() bool {
+		m, _ := source.Metadata()
+		return m != good
+	})
+	m, _ = source.Metadata()
+	checkStubMetadata(t, m, "Renamed", "b")
+
+	// until it's closed
+	source.Close()
+	queries = stub.queryCount()
+	time.Sleep(5 * interval)
+	if stub.queryCount() != queries {
+		t.Error("database was still queried after Close")
+	}
+}
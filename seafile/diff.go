@@ -0,0 +1,295 @@
+package seafile
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// ChangeType describes how a path differs between two Commits.
+type ChangeType int
+
+const (
+	ChangeAdded ChangeType = iota
+	ChangeDeleted
+	ChangeModified
+	ChangeRenamed
+	ChangeMoved
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case ChangeAdded:
+		return "added"
+	case ChangeDeleted:
+		return "deleted"
+	case ChangeModified:
+		return "modified"
+	case ChangeRenamed:
+		return "renamed"
+	case ChangeMoved:
+		return "moved"
+	}
+
+	return "unknown"
+}
+
+// Change is a single difference reported by Diff.
+type Change struct {
+	Type ChangeType
+
+	// Path is the path of the file or directory in the newer Commit, or in the older Commit if it was deleted.
+	Path string
+
+	// OldPath is the path in the older Commit of a renamed or moved file or directory.
+	OldPath string
+
+	IsDir bool
+
+	// OldID and NewID are the object IDs on each side. OldID is empty for additions, and NewID is empty for
+	// deletions.
+	OldID string
+	NewID string
+}
+
+type diffState struct {
+	a, b *Commit
+
+	changes []Change
+}
+
+// Diff compares the trees of two Commits and returns what changed going from a to b, sorted by path. Subtrees with the
+// same object ID on both sides are skipped without being read.
+//
+// The contents of an added or deleted directory are reported along with the directory itself. An added and a deleted
+// entry with the same content are reported as a single rename, if they are in the same directory, or a single move
+// otherwise. For a virtual repo, whose root is the shared folder, paths are relative to that folder.
+func Diff(a, b *Commit) ([]Change, error) {
+	d := diffState{
+		a: a,
+		b: b,
+	}
+
+	err := d.diffDir("", a.RootID, b.RootID)
+	if err != nil {
+		return nil, err
+	}
+
+	d.resolveRenames()
+
+	sort.SliceStable(d.changes, func(i, j int) bool {
+		return d.changes[i].Path < d.changes[j].Path
+	})
+
+	return d.changes, nil
+}
+
+func direntIsDir(d *direntInternal) bool {
+	return (d.Mode & modeIsDir) != 0
+}
+
+func (d *diffState) readDirents(c *Commit, id string) ([]direntInternal, error) {
+	if id == emptyID {
+		return []direntInternal{}, nil
+	}
+
+	i, err := c.repo.readFSObject(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return i.Dirents, nil
+}
+
+func (d *diffState) diffDir(dirPath string, oldID string, newID string) error {
+	if oldID == newID {
+		return nil
+	}
+
+	oldDirents, err := d.readDirents(d.a, oldID)
+	if err != nil {
+		return err
+	}
+	newDirents, err := d.readDirents(d.b, newID)
+	if err != nil {
+		return err
+	}
+
+	oldByName := map[string]*direntInternal{}
+	for i := range oldDirents {
+		oldByName[oldDirents[i].Name] = &oldDirents[i]
+	}
+
+	for i := range newDirents {
+		newDirent := &newDirents[i]
+		entryPath := path.Join(dirPath, newDirent.Name)
+
+		oldDirent, exists := oldByName[newDirent.Name]
+		if !exists {
+			err = d.addTree(ChangeAdded, entryPath, newDirent)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		delete(oldByName, newDirent.Name)
+
+		if direntIsDir(oldDirent) != direntIsDir(newDirent) {
+			// replaced with something of a different type
+			err = d.addTree(ChangeDeleted, entryPath, oldDirent)
+			if err != nil {
+				return err
+			}
+			err = d.addTree(ChangeAdded, entryPath, newDirent)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if direntIsDir(newDirent) {
+			err = d.diffDir(entryPath, oldDirent.ID, newDirent.ID)
+			if err != nil {
+				return err
+			}
+		} else if oldDirent.ID != newDirent.ID {
+			d.changes = append(d.changes, Change{
+				Type:  ChangeModified,
+				Path:  entryPath,
+				OldID: oldDirent.ID,
+				NewID: newDirent.ID,
+			})
+		}
+	}
+
+	for i := range oldDirents {
+		oldDirent := &oldDirents[i]
+		if _, stillThere := oldByName[oldDirent.Name]; !stillThere {
+			continue
+		}
+
+		err = d.addTree(ChangeDeleted, path.Join(dirPath, oldDirent.Name), oldDirent)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addTree records an addition or deletion of the given entry and, if it is a directory, everything inside it.
+func (d *diffState) addTree(t ChangeType, entryPath string, dirent *direntInternal) error {
+	change := Change{
+		Type:  t,
+		Path:  entryPath,
+		IsDir: direntIsDir(dirent),
+	}
+	c := d.b
+	if t == ChangeAdded {
+		change.NewID = dirent.ID
+	} else {
+		change.OldID = dirent.ID
+		c = d.a
+	}
+	d.changes = append(d.changes, change)
+
+	if !change.IsDir {
+		return nil
+	}
+
+	children, err := d.readDirents(c, dirent.ID)
+	if err != nil {
+		return err
+	}
+
+	for i := range children {
+		err = d.addTree(t, path.Join(entryPath, children[i].Name), &children[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isUnder(p string, dir string) bool {
+	return strings.HasPrefix(p, dir+"/")
+}
+
+// resolveRenames pairs up additions and deletions of the same object. Directories are paired first, outermost first,
+// so that the contents of a moved directory are not reported separately.
+func (d *diffState) resolveRenames() {
+	for _, dirs := range []bool{true, false} {
+		deletedByID := map[string][]int{}
+		added := []int{}
+		for i, change := range d.changes {
+			if change.IsDir != dirs || change.Type == ChangeModified {
+				continue
+			}
+			if change.Type == ChangeDeleted && change.OldID != emptyID {
+				deletedByID[change.OldID] = append(deletedByID[change.OldID], i)
+			} else if change.Type == ChangeAdded && change.NewID != emptyID {
+				added = append(added, i)
+			}
+		}
+
+		// outermost first
+		sort.SliceStable(added, func(i, j int) bool {
+			return strings.Count(d.changes[added[i]].Path, "/") < strings.Count(d.changes[added[j]].Path, "/")
+		})
+
+		removed := map[int]bool{}
+		for _, addedIdx := range added {
+			if removed[addedIdx] {
+				continue
+			}
+
+			addedChange := &d.changes[addedIdx]
+			candidates := deletedByID[addedChange.NewID]
+
+			deletedIdx := -1
+			for _, candidateIdx := range candidates {
+				if removed[candidateIdx] {
+					continue
+				}
+				if deletedIdx == -1 || path.Base(d.changes[candidateIdx].Path) == path.Base(addedChange.Path) {
+					deletedIdx = candidateIdx
+				}
+			}
+			if deletedIdx == -1 {
+				continue
+			}
+
+			oldPath := d.changes[deletedIdx].Path
+			removed[deletedIdx] = true
+
+			addedChange.OldPath = oldPath
+			addedChange.OldID = addedChange.NewID
+			if path.Dir(oldPath) == path.Dir(addedChange.Path) {
+				addedChange.Type = ChangeRenamed
+			} else {
+				addedChange.Type = ChangeMoved
+			}
+
+			if dirs {
+				// the contents moved along with the directory
+				for i, change := range d.changes {
+					if change.Type == ChangeAdded && isUnder(change.Path, addedChange.Path) {
+						removed[i] = true
+					}
+					if change.Type == ChangeDeleted && isUnder(change.Path, oldPath) {
+						removed[i] = true
+					}
+				}
+			}
+		}
+
+		remaining := []Change{}
+		for i, change := range d.changes {
+			if !removed[i] {
+				remaining = append(remaining, change)
+			}
+		}
+		d.changes = remaining
+	}
+}
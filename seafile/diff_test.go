@@ -0,0 +1,178 @@
+package seafile_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/thatoddmailbox/seafile-browse/seafile"
+	"github.com/thatoddmailbox/seafile-browse/seafile/seafiletest"
+)
+
+// describeChanges turns changes into strings like "renamed a -> b", which are easier to compare and read.
+func describeChanges(changes []seafile.Change) []string {
+	result := []string{}
+	for _, change := range changes {
+		s := change.Type.String() + " "
+		if change.OldPath != "" {
+			s += change.OldPath + " -> "
+		}
+		s += change.Path
+		if change.IsDir {
+			s += "/"
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+// diffCommits builds a library from the given commits and diffs each pair of indexes.
+func diffCommits(t *testing.T, commits []seafiletest.Commit, pairs [][2]int) [][]string {
+	t.Helper()
+
+	d := &seafiletest.Data{
+		Libraries: []seafiletest.Library{
+			{Name: "Docs", Owner: "alice@example.com", Commits: commits},
+		},
+	}
+	s := openTestStorage(t, d)
+
+	r, err := s.OpenRepo(d.Libraries[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := [][]string{}
+	for _, pair := range pairs {
+		a, err := r.GetCommit(d.Libraries[0].Commits[pair[0]].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := r.GetCommit(d.Libraries[0].Commits[pair[1]].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		changes, err := seafile.Diff(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, describeChanges(changes))
+	}
+	return result
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b map[string]string
+		want []string
+	}{
+		{
+			name: "nothing",
+			a:    map[string]string{"a.txt": "a", "d/b.txt": "b"},
+			b:    map[string]string{"a.txt": "a", "d/b.txt": "b"},
+			want: []string{},
+		},
+		{
+			name: "add and modify",
+			a:    map[string]string{"a.txt": "a"},
+			b:    map[string]string{"a.txt": "a2", "new.txt": "new", "d/e/f.txt": "f"},
+			want: []string{"modified a.txt", "added d/", "added d/e/", "added d/e/f.txt", "added new.txt"},
+		},
+		{
+			name: "delete directory",
+			a:    map[string]string{"keep.txt": "k", "d/b.txt": "b", "d/e/": ""},
+			b:    map[string]string{"keep.txt": "k"},
+			want: []string{"deleted d/", "deleted d/b.txt", "deleted d/e/"},
+		},
+		{
+			name: "rename plus modify",
+			a:    map[string]string{"docs/a.txt": "alpha", "docs/b.txt": "beta"},
+			b:    map[string]string{"docs/a2.txt": "alpha", "docs/b.txt": "beta, edited"},
+			want: []string{"renamed docs/a.txt -> docs/a2.txt", "modified docs/b.txt"},
+		},
+		{
+			// without the same content, there's nothing to pair them up by
+			name: "rename with changed content",
+			a:    map[string]string{"a.txt": "alpha"},
+			b:    map[string]string{"b.txt": "alpha, edited"},
+			want: []string{"deleted a.txt", "added b.txt"},
+		},
+		{
+			name: "move file",
+			a:    map[string]string{"a/x.txt": "x", "b/keep.txt": "k"},
+			b:    map[string]string{"b/x.txt": "x", "b/keep.txt": "k"},
+			want: []string{"deleted a/", "moved a/x.txt -> b/x.txt"},
+		},
+		{
+			name: "rename directory",
+			a:    map[string]string{"old/x.txt": "x", "old/sub/y.txt": "y"},
+			b:    map[string]string{"new/x.txt": "x", "new/sub/y.txt": "y"},
+			want: []string{"renamed old -> new/"},
+		},
+		{
+			name: "move directory",
+			a:    map[string]string{"a/d/x.txt": "x", "b/keep.txt": "k", "a/keep.txt": "k2"},
+			b:    map[string]string{"b/d/x.txt": "x", "b/keep.txt": "k", "a/keep.txt": "k2"},
+			want: []string{"moved a/d -> b/d/"},
+		},
+		{
+			// of two identical copies, the one with the same name is the one that was moved
+			name: "move with duplicate content",
+			a:    map[string]string{"a/one.txt": "same", "a/two.txt": "same"},
+			b:    map[string]string{"b/two.txt": "same"},
+			want: []string{"deleted a/", "deleted a/one.txt", "added b/", "moved a/two.txt -> b/two.txt"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := diffCommits(t, []seafiletest.Commit{commitAt(0, test.a), commitAt(1, test.b)}, [][2]int{{0, 1}})[0]
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDiffDeleteThenReAdd(t *testing.T) {
+	commits := []seafiletest.Commit{
+		commitAt(0, map[string]string{"f.txt": "v1", "d/g.txt": "g"}),
+		commitAt(1, map[string]string{"d/g.txt": "g"}),
+		commitAt(2, map[string]string{"f.txt": "v1", "d/g.txt": "g"}),
+	}
+
+	got := diffCommits(t, commits, [][2]int{{0, 1}, {1, 2}, {0, 2}, {2, 0}})
+	want := [][]string{
+		{"deleted f.txt"},
+		{"added f.txt"},
+		// the re-added file has a new modification time, so the root differs, but nothing is reported
+		{},
+		{},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDiffIsReversible(t *testing.T) {
+	commits := []seafiletest.Commit{
+		commitAt(0, map[string]string{"a/x.txt": "x", "b.txt": "b", "c.txt": "c"}),
+		commitAt(1, map[string]string{"z/x.txt": "x", "b.txt": "b2", "d.txt": "d"}),
+	}
+
+	got := diffCommits(t, commits, [][2]int{{0, 1}, {1, 0}})
+	want := [][]string{
+		{"modified b.txt", "deleted c.txt", "added d.txt", "renamed a -> z/"},
+		{"renamed z -> a/", "modified b.txt", "added c.txt", "deleted d.txt"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func ExampleChangeType_String() {
+	fmt.Println(seafile.ChangeMoved)
+	// Output: moved
+}
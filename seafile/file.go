@@ -7,12 +7,17 @@ import (
 	"io"
 	"io/fs"
 	"path"
+	"sort"
 	"strings"
+	"sync"
 )
 
 const typeFile = 1
 const typeDir = 3
 
+// emptyID is the object ID Seafile uses for empty files and directories, which have no fs object.
+const emptyID = "0000000000000000000000000000000000000000"
+
 type direntInternal struct {
 	ID       string `json:"id"`
 	Mode     uint32 `json:"mode"`
@@ -25,6 +30,7 @@ type direntInternal struct {
 type fileInternal struct {
 	// only for files
 	BlockIDs []string `json:"block_ids"`
+	Size     int64    `json:"size"`
 
 	// only for dirs
 	Dirents []direntInternal `json:"dirents"`
@@ -48,6 +54,12 @@ type File struct {
 	blockRemaining  int64
 	blockIdx        uint
 	blockFile       fs.File
+
+	// blockSkip is how far into the block at blockIdx the next Read should start, after a Seek
+	blockSkip int64
+
+	blockOffsetsLock sync.Mutex
+	blockOffsets     []int64
 }
 
 func (f *File) openSub(sub string) (*File, error) {
@@ -60,23 +72,30 @@ func (f *File) openSub(sub string) (*File, error) {
 	return nil, fs.ErrNotExist
 }
 
-func (f *File) open(name string) (*File, error) {
-	// remove trailing slash
-	name = strings.TrimSuffix(name, "/")
+// reopen returns a new File for the same object, with its own read state.
+func (f *File) reopen() *File {
+	return &File{
+		seafileFsys: f.seafileFsys,
+		fileID:      f.fileID,
+
+		i: f.i,
+		d: f.d,
+	}
+}
 
-	parts := strings.Split(name, "/")
+// open opens the given path relative to f, which must be valid according to fs.ValidPath.
+func (f *File) open(name string) (*File, error) {
+	if !fs.ValidPath(name) {
+		return nil, fs.ErrInvalid
+	}
 
-	if len(name) == 0 {
-		parts = []string{}
+	if name == "." {
+		return f.reopen(), nil
 	}
 
 	currentLevel := f
 	var err error
-	for _, part := range parts {
-		if part == "." {
-			continue
-		}
-
+	for _, part := range strings.Split(name, "/") {
 		currentLevel, err = currentLevel.openSub(part)
 		if err != nil {
 			return nil, err
@@ -92,12 +111,126 @@ func (f *File) Stat() (fs.FileInfo, error) {
 	}, nil
 }
 
-func (f *File) openBlockIdx(i uint) (fs.File, error) {
-	blockID := f.i.BlockIDs[f.blockIdx]
-	blockPath := path.Join("storage", "blocks", f.seafileFsys.c.repoID, blockID[:2], blockID[2:])
+func (f *File) openRawBlockIdx(i uint) (fs.File, error) {
+	blockID := f.i.BlockIDs[i]
+	blockPath := path.Join("storage", "blocks", f.seafileFsys.c.repo.storeID, blockID[:2], blockID[2:])
 	return f.seafileFsys.c.fsys.Open(blockPath)
 }
 
+// openVerifiedBlockIdx returns the contents of the block at the given index, after checking them against its ID.
+func (f *File) openVerifiedBlockIdx(i uint) (fs.File, error) {
+	r := f.seafileFsys.c.repo
+	blockID := f.i.BlockIDs[i]
+
+	data, cached := r.s.blockCache.get(r.storeID, blockID)
+	if !cached {
+		blockFile, err := f.openRawBlockIdx(i)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err = verifyBlock(blockFile, r.storeID, blockID)
+		if err != nil {
+			return nil, err
+		}
+
+		r.s.blockCache.put(r.storeID, blockID, data)
+	}
+
+	return newMemoryFile(blockID[2:], data), nil
+}
+
+func (f *File) openBlockIdx(i uint) (fs.File, error) {
+	var blockFile fs.File
+	var err error
+	if f.seafileFsys.c.repo.s.verifyContent {
+		blockFile, err = f.openVerifiedBlockIdx(i)
+	} else {
+		blockFile, err = f.openRawBlockIdx(i)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if f.seafileFsys.c.Encrypted {
+		return f.seafileFsys.c.repo.crypt.decryptBlock(blockFile)
+	}
+
+	return blockFile, nil
+}
+
+// blockSize returns the size of the contents of the block at the given index.
+func (f *File) blockSize(i uint) (int64, error) {
+	blockFile, err := f.openRawBlockIdx(i)
+	if err != nil {
+		return 0, err
+	}
+	defer blockFile.Close()
+
+	blockFileInfo, err := blockFile.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	if f.seafileFsys.c.Encrypted {
+		return f.seafileFsys.c.repo.crypt.plaintextSize(blockFile, blockFileInfo.Size())
+	}
+
+	return blockFileInfo.Size(), nil
+}
+
+// blockIndex returns the offset of the start of each block in the file, followed by the size of the file. It is built
+// the first time it's needed, which requires looking at the size of every block.
+func (f *File) blockIndex() ([]int64, error) {
+	f.blockOffsetsLock.Lock()
+	defer f.blockOffsetsLock.Unlock()
+
+	if f.blockOffsets != nil {
+		return f.blockOffsets, nil
+	}
+
+	offsets := make([]int64, len(f.i.BlockIDs)+1)
+	for i := range f.i.BlockIDs {
+		size, err := f.blockSize(uint(i))
+		if err != nil {
+			return nil, err
+		}
+
+		offsets[i+1] = offsets[i] + size
+	}
+
+	f.blockOffsets = offsets
+	return offsets, nil
+}
+
+// findBlock returns the index of the block containing the given offset, using the block index.
+func findBlock(offsets []int64, offset int64) int {
+	return sort.Search(len(offsets)-1, func(i int) bool {
+		return offsets[i+1] > offset
+	})
+}
+
+// readAtOffset fills b with the data at the given offset of f, using whatever f supports.
+func readAtOffset(f fs.File, b []byte, offset int64) (int, error) {
+	if readerAt, ok := f.(io.ReaderAt); ok {
+		return readerAt.ReadAt(b, offset)
+	}
+
+	if seeker, ok := f.(io.Seeker); ok {
+		_, err := seeker.Seek(offset, io.SeekStart)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		_, err := io.CopyN(io.Discard, f, offset)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return io.ReadFull(f, b)
+}
+
 func (f *File) Read(b []byte) (int, error) {
 	if f.closed {
 		return 0, fs.ErrClosed
@@ -115,6 +248,11 @@ func (f *File) Read(b []byte) (int, error) {
 		var err error
 
 		if f.blockFile == nil {
+			if int(f.blockIdx) >= len(f.i.BlockIDs) {
+				// the blocks are shorter than the file is supposed to be
+				return totalRead, io.ErrUnexpectedEOF
+			}
+
 			f.blockFile, err = f.openBlockIdx(f.blockIdx)
 			if err != nil {
 				return totalRead, err
@@ -125,9 +263,30 @@ func (f *File) Read(b []byte) (int, error) {
 				return totalRead, err
 			}
 			f.blockRemaining = blockFileInfo.Size()
+
+			if f.blockSkip > 0 {
+				// a Seek left us partway into this block
+				seeker, ok := f.blockFile.(io.Seeker)
+				if ok {
+					_, err = seeker.Seek(f.blockSkip, io.SeekStart)
+				} else {
+					_, err = io.CopyN(io.Discard, f.blockFile, f.blockSkip)
+				}
+				if err != nil {
+					return totalRead, err
+				}
+
+				f.blockRemaining -= f.blockSkip
+				f.blockSkip = 0
+			}
+		}
+
+		readSize := totalRequested
+		if readSize > f.blockRemaining {
+			readSize = f.blockRemaining
 		}
 
-		n, err := f.blockFile.Read(b[totalRead:])
+		n, err := f.blockFile.Read(b[totalRead : int64(totalRead)+readSize])
 		totalRead += n
 		if err != nil && err != io.EOF {
 			return totalRead, err
@@ -139,7 +298,7 @@ func (f *File) Read(b []byte) (int, error) {
 		totalRemaining -= int64(n)
 		f.blockRemaining -= int64(n)
 
-		if f.blockRemaining == 0 || err == io.EOF {
+		if f.blockRemaining <= 0 || err == io.EOF {
 			// onto the next block
 			f.blockIdx++
 
@@ -160,8 +319,13 @@ func (f *File) Read(b []byte) (int, error) {
 }
 
 func (f *File) Seek(offset int64, whence int) (int64, error) {
-	// TODO: this is not really efficient, ESPECIALLY for SeekCurrent/SeekEnd
-	// TODO: this can be heavily optimized
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+
+	if f.i.Type != typeFile {
+		return 0, fs.ErrInvalid
+	}
 
 	absoluteOffset := offset
 	if whence == io.SeekCurrent {
@@ -170,7 +334,7 @@ func (f *File) Seek(offset int64, whence int) (int64, error) {
 		absoluteOffset = f.d.Size + offset
 	}
 
-	if absoluteOffset < -1 {
+	if absoluteOffset < 0 {
 		return f.totalByteOffset, errors.New("seafile: tried to seek before start")
 	}
 	if absoluteOffset > f.d.Size {
@@ -186,58 +350,86 @@ func (f *File) Seek(offset int64, whence int) (int64, error) {
 		f.blockFile = nil
 	}
 
-	f.totalByteOffset = 0
-	f.blockRemaining = 0
-	f.blockIdx = 0
+	if absoluteOffset == 0 {
+		// no need to know where the blocks are for this
+		f.totalByteOffset = 0
+		f.blockRemaining = 0
+		f.blockIdx = 0
+		f.blockSkip = 0
+		return 0, nil
+	}
 
-	var err error
+	offsets, err := f.blockIndex()
+	if err != nil {
+		return f.totalByteOffset, err
+	}
 
-	for f.totalByteOffset < absoluteOffset {
-		f.blockFile, err = f.openBlockIdx(f.blockIdx)
-		if err != nil {
-			return f.totalByteOffset, err
-		}
+	blockIdx := findBlock(offsets, absoluteOffset)
 
-		blockFileInfo, err := f.blockFile.Stat()
-		if err != nil {
-			return f.totalByteOffset, err
-		}
-		f.blockRemaining = blockFileInfo.Size()
+	f.totalByteOffset = absoluteOffset
+	f.blockRemaining = 0
+	f.blockIdx = uint(blockIdx)
+	f.blockSkip = 0
+	if blockIdx < len(f.i.BlockIDs) {
+		f.blockSkip = absoluteOffset - offsets[blockIdx]
+	}
+
+	return f.totalByteOffset, nil
+}
 
-		distanceRemaining := absoluteOffset - f.totalByteOffset
+// ReadAt reads len(b) bytes from the File starting at the given offset. It does not use or change the offset used by
+// Read and Seek, and it is safe to call from multiple goroutines at once.
+func (f *File) ReadAt(b []byte, offset int64) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
 
-		if f.blockRemaining < distanceRemaining {
-			// we need to seek to the right place in this block
-			// then get out of here
+	if f.i.Type != typeFile {
+		return 0, fs.ErrInvalid
+	}
 
-			blockFileSeek, ok := f.blockFile.(io.Seeker)
-			if !ok {
-				return f.totalByteOffset, errors.New("seafile: underlying fs.File does not implement io.Seeker")
-			}
+	if offset < 0 {
+		return 0, errors.New("seafile: tried to read before start")
+	}
+	if offset >= f.d.Size {
+		return 0, io.EOF
+	}
 
-			blockOffset, err := blockFileSeek.Seek(distanceRemaining, io.SeekStart)
-			if err != nil {
-				return f.totalByteOffset, err
-			}
+	offsets, err := f.blockIndex()
+	if err != nil {
+		return 0, err
+	}
 
-			f.totalByteOffset += blockOffset
-			f.blockRemaining -= blockOffset
-			break
-		}
+	totalRead := 0
+	for blockIdx := findBlock(offsets, offset); totalRead < len(b) && blockIdx < len(f.i.BlockIDs); blockIdx++ {
+		currentOffset := offset + int64(totalRead)
 
-		// skip this block
-		f.totalByteOffset += f.blockRemaining
-		f.blockRemaining = 0
-		f.blockIdx++
+		readSize := int64(len(b) - totalRead)
+		if readSize > offsets[blockIdx+1]-currentOffset {
+			readSize = offsets[blockIdx+1] - currentOffset
+		}
 
-		err = f.blockFile.Close()
+		blockFile, err := f.openBlockIdx(uint(blockIdx))
 		if err != nil {
-			return f.totalByteOffset, err
+			return totalRead, err
+		}
+
+		n, err := readAtOffset(blockFile, b[totalRead:int64(totalRead)+readSize], currentOffset-offsets[blockIdx])
+		blockFile.Close()
+		totalRead += n
+		if err != nil && err != io.EOF {
+			return totalRead, err
+		}
+		if int64(n) < readSize {
+			return totalRead, io.ErrUnexpectedEOF
 		}
-		f.blockFile = nil
 	}
 
-	return f.totalByteOffset, nil
+	if totalRead < len(b) {
+		return totalRead, io.EOF
+	}
+
+	return totalRead, nil
 }
 
 func (f *File) ReadDir(n int) ([]fs.DirEntry, error) {
@@ -245,21 +437,24 @@ func (f *File) ReadDir(n int) ([]fs.DirEntry, error) {
 		return []fs.DirEntry{}, fs.ErrInvalid
 	}
 
-	result := []fs.DirEntry{}
-
-	for i := range f.i.Dirents[f.direntIdx:] {
-		if n > 0 && i == n {
-			break
+	remaining := f.i.Dirents[f.direntIdx:]
+	if n > 0 {
+		if len(remaining) == 0 {
+			return []fs.DirEntry{}, io.EOF
 		}
 
-		result = append(result, &DirEntry{
-			d: &f.i.Dirents[i],
-		})
+		if len(remaining) > n {
+			remaining = remaining[:n]
+		}
+	}
 
-		if n > 0 {
-			f.direntIdx++
+	result := make([]fs.DirEntry, len(remaining))
+	for i := range remaining {
+		result[i] = &DirEntry{
+			d: &remaining[i],
 		}
 	}
+	f.direntIdx += len(remaining)
 
 	return result, nil
 }
@@ -292,7 +487,15 @@ func newFile(seafileFsys *FS, fileID string, d *direntInternal) (*File, error) {
 		blockIdx:        0,
 	}
 
-	if fileID == "0000000000000000000000000000000000000000" || (d != nil && d.ID == "0000000000000000000000000000000000000000" && ((d.Mode & modeIsDir) != 0)) {
+	if fileID == emptyID && d != nil && !direntIsDir(d) {
+		// it's an empty file, which has no fs object
+		ret.i.BlockIDs = []string{}
+		ret.i.Type = typeFile
+		ret.i.Version = 1
+		return &ret, nil
+	}
+
+	if fileID == emptyID {
 		// it's an empty directory, special case
 		// TODO: is version right?
 		ret.i.Dirents = []direntInternal{}
@@ -301,23 +504,59 @@ func newFile(seafileFsys *FS, fileID string, d *direntInternal) (*File, error) {
 		return &ret, nil
 	}
 
-	fsPath := path.Join("storage", "fs", seafileFsys.c.repoID, fileID[:2], fileID[2:])
-	f, err := seafileFsys.c.fsys.Open(fsPath)
+	var err error
+	ret.i, err = seafileFsys.c.repo.readFSObject(fileID)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
 
-	r, err := zlib.NewReader(f)
+	return &ret, nil
+}
+
+// readFSObject reads and decodes the fs object with the given ID, using the Storage's cache if possible. The result
+// may be shared, and must not be modified.
+func (r *Repo) readFSObject(id string) (fileInternal, error) {
+	i, cached := r.s.fsCache.get(r.storeID, id, r.s.verifyContent)
+	if cached {
+		return i, nil
+	}
+
+	fsPath := path.Join("storage", "fs", r.storeID, id[:2], id[2:])
+	f, err := r.fsys.Open(fsPath)
 	if err != nil {
-		return nil, err
+		return i, err
 	}
-	defer r.Close()
+	defer f.Close()
 
-	err = json.NewDecoder(r).Decode(&ret.i)
+	zr, err := zlib.NewReader(f)
 	if err != nil {
-		return nil, err
+		return i, err
 	}
+	defer zr.Close()
 
-	return &ret, nil
+	if r.s.verifyContent {
+		data, err := io.ReadAll(zr)
+		if err != nil {
+			return i, err
+		}
+
+		err = verifyData("fs object", r.storeID, id, data)
+		if err != nil {
+			return i, err
+		}
+
+		err = json.Unmarshal(data, &i)
+		if err != nil {
+			return i, err
+		}
+	} else {
+		err = json.NewDecoder(zr).Decode(&i)
+		if err != nil {
+			return i, err
+		}
+	}
+
+	r.s.fsCache.put(r.storeID, id, i, r.s.verifyContent)
+
+	return i, nil
 }
@@ -0,0 +1,141 @@
+package seafile
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrInvalidPath is returned by FileHistory when given the root directory or an otherwise unusable path.
+var ErrInvalidPath = errors.New("seafile: invalid path")
+
+// FileVersion is a single version of a file, as returned by FileHistory.
+type FileVersion struct {
+	// Commit is the Commit that introduced this version.
+	Commit *Commit
+
+	// DeletedBy is the Commit that removed the file, or nil if it was not removed before another version replaced it
+	// or it is still present.
+	DeletedBy *Commit
+
+	ID       string
+	IsDir    bool
+	Size     int64
+	MTime    time.Time
+	Modifier string
+}
+
+// resolvedPath remembers the object IDs along the path to a file in one Commit, so that the lookup in the next Commit
+// can skip directories that did not change.
+type resolvedPath struct {
+	dirIDs []string
+	dirent *direntInternal
+}
+
+func (r *Repo) resolvePath(c *Commit, parts []string, prev *resolvedPath) (*resolvedPath, error) {
+	result := &resolvedPath{
+		dirIDs: []string{c.RootID},
+	}
+
+	currentID := c.RootID
+	for level, part := range parts {
+		if prev != nil && len(prev.dirIDs) > level && prev.dirIDs[level] == currentID {
+			// this directory is unchanged, so everything below it is too
+			result.dirIDs = append(result.dirIDs[:level], prev.dirIDs[level:]...)
+			result.dirent = prev.dirent
+			return result, nil
+		}
+
+		if currentID == emptyID {
+			return result, nil
+		}
+
+		i, err := r.readFSObject(currentID)
+		if err != nil {
+			return nil, err
+		}
+
+		var found *direntInternal
+		for j := range i.Dirents {
+			if i.Dirents[j].Name == part {
+				found = &i.Dirents[j]
+				break
+			}
+		}
+		if found == nil {
+			return result, nil
+		}
+
+		if level == len(parts)-1 {
+			result.dirent = found
+			return result, nil
+		}
+
+		if !direntIsDir(found) {
+			return result, nil
+		}
+
+		currentID = found.ID
+		result.dirIDs = append(result.dirIDs, currentID)
+	}
+
+	return result, nil
+}
+
+// FileHistory returns every distinct version of the file or directory at the given path, newest first. Commits are
+// considered in the order they were made. For a virtual repo, the path is relative to the shared folder.
+func (r *Repo) FileHistory(filePath string) ([]FileVersion, error) {
+	filePath = path.Clean(strings.TrimPrefix(filePath, "/"))
+	if filePath == "." || filePath == "" {
+		return nil, ErrInvalidPath
+	}
+	parts := strings.Split(filePath, "/")
+
+	commits, err := r.History(HistoryOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []FileVersion{}
+	current := -1
+	var prev *resolvedPath
+	for i := len(commits) - 1; i >= 0; i-- {
+		c := commits[i]
+
+		resolved, err := r.resolvePath(c, parts, prev)
+		if err != nil {
+			return nil, err
+		}
+		prev = resolved
+
+		if resolved.dirent == nil {
+			if current != -1 {
+				versions[current].DeletedBy = c
+				current = -1
+			}
+			continue
+		}
+
+		if current != -1 && versions[current].ID == resolved.dirent.ID {
+			continue
+		}
+
+		versions = append(versions, FileVersion{
+			Commit:   c,
+			ID:       resolved.dirent.ID,
+			IsDir:    direntIsDir(resolved.dirent),
+			Size:     resolved.dirent.Size,
+			MTime:    time.Unix(int64(resolved.dirent.MTime), 0),
+			Modifier: resolved.dirent.Modifier,
+		})
+		current = len(versions) - 1
+	}
+
+	// newest first, like History
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+
+	return versions, nil
+}
@@ -0,0 +1,193 @@
+package seafile_test
+
+import (
+	"testing"
+
+	"github.com/thatoddmailbox/seafile-browse/seafile"
+	"github.com/thatoddmailbox/seafile-browse/seafile/seafiletest"
+)
+
+// fileVersion is the part of a seafile.FileVersion that the tests check, with Commits given by their index.
+type fileVersion struct {
+	commit    int
+	deletedBy int
+	size      int64
+	modifier  string
+}
+
+func checkFileHistory(t *testing.T, lib seafiletest.Library, r *seafile.Repo, filePath string, want []fileVersion) {
+	t.Helper()
+
+	commitIndex := map[string]int{}
+	for i, c := range lib.Commits {
+		commitIndex[c.ID] = i
+	}
+
+	versions, err := r.FileHistory(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []fileVersion{}
+	for _, v := range versions {
+		fv := fileVersion{
+			commit:    commitIndex[v.Commit.CommitID],
+			deletedBy: -1,
+			size:      v.Size,
+			modifier:  v.Modifier,
+		}
+		if v.DeletedBy != nil {
+			fv.deletedBy = commitIndex[v.DeletedBy.CommitID]
+		}
+		got = append(got, fv)
+
+		if !v.MTime.Equal(lib.Commits[fv.commit].Time) {
+			t.Errorf("%s: version from commit %d has mtime %v, want %v", filePath, fv.commit, v.MTime, lib.Commits[fv.commit].Time)
+		}
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("%s: got versions %+v, want %+v", filePath, got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("%s: got versions %+v, want %+v", filePath, got, want)
+			break
+		}
+	}
+}
+
+func TestFileHistory(t *testing.T) {
+	edit := commitAt(1, map[string]string{"d/f.txt": "version two", "other.txt": "o"})
+	edit.Creator = "bob@example.com"
+
+	d := &seafiletest.Data{
+		Libraries: []seafiletest.Library{
+			{
+				Name:  "Docs",
+				Owner: "alice@example.com",
+				Commits: []seafiletest.Commit{
+					commitAt(0, map[string]string{"d/f.txt": "v1", "other.txt": "o"}),
+					edit,
+					// unrelated changes don't make a new version
+					commitAt(2, map[string]string{"d/f.txt": "version two", "other.txt": "o2"}),
+					commitAt(3, map[string]string{"d/g.txt": "g", "other.txt": "o2"}),
+					// re-added with the first contents, which is still a new version
+					commitAt(4, map[string]string{"d/f.txt": "v1", "d/g.txt": "g", "other.txt": "o2"}),
+				},
+			},
+		},
+	}
+	s := openTestStorage(t, d)
+
+	r, err := s.OpenRepo(d.Libraries[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkFileHistory(t, d.Libraries[0], r, "d/f.txt", []fileVersion{
+		{commit: 4, deletedBy: -1, size: 2, modifier: "alice@example.com"},
+		{commit: 1, deletedBy: 3, size: 11, modifier: "bob@example.com"},
+		{commit: 0, deletedBy: -1, size: 2, modifier: "alice@example.com"},
+	})
+
+	// leading slashes and redundant elements are cleaned up
+	checkFileHistory(t, d.Libraries[0], r, "/d/./g.txt", []fileVersion{
+		{commit: 3, deletedBy: -1, size: 1, modifier: "alice@example.com"},
+	})
+
+	checkFileHistory(t, d.Libraries[0], r, "missing.txt", []fileVersion{})
+
+	versions, err := r.FileHistory("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the directory changes whenever something in it does
+	if len(versions) != 4 {
+		t.Errorf("d has %d versions, want 4", len(versions))
+	}
+	for _, v := range versions {
+		if !v.IsDir {
+			t.Errorf("version of d from %s is not a directory", v.Commit.CommitID)
+		}
+	}
+
+	for _, p := range []string{"", "/", "."} {
+		_, err = r.FileHistory(p)
+		if err != seafile.ErrInvalidPath {
+			t.Errorf("FileHistory(%q) returned %v, want ErrInvalidPath", p, err)
+		}
+	}
+}
+
+func TestFileHistoryAcrossRename(t *testing.T) {
+	d := &seafiletest.Data{
+		Libraries: []seafiletest.Library{
+			{
+				Name:  "Docs",
+				Owner: "alice@example.com",
+				Commits: []seafiletest.Commit{
+					commitAt(0, map[string]string{"old/a.txt": "a"}),
+					commitAt(1, map[string]string{"old/a.txt": "a, edited"}),
+					commitAt(2, map[string]string{"new/b.txt": "a, edited"}),
+					commitAt(3, map[string]string{"new/b.txt": "b"}),
+				},
+			},
+		},
+	}
+	s := openTestStorage(t, d)
+
+	r, err := s.OpenRepo(d.Libraries[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// history is kept per path, so a rename ends the history of the old path and starts that of the new one, which
+	// keeps the modification time of the version that was renamed
+	checkFileHistory(t, d.Libraries[0], r, "old/a.txt", []fileVersion{
+		{commit: 1, deletedBy: 2, size: 9, modifier: "alice@example.com"},
+		{commit: 0, deletedBy: -1, size: 1, modifier: "alice@example.com"},
+	})
+
+	versions, err := r.FileHistory("new/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("new/b.txt has %d versions, want 2", len(versions))
+	}
+	if versions[1].Commit.CommitID != d.Libraries[0].Commits[2].ID {
+		t.Errorf("new/b.txt first appears in %s, want the rename", versions[1].Commit.CommitID)
+	}
+	if !versions[1].MTime.Equal(d.Libraries[0].Commits[1].Time) {
+		t.Errorf("renamed version has mtime %v, want that of the edit", versions[1].MTime)
+	}
+}
+
+func TestFileHistoryVirtualRepo(t *testing.T) {
+	d := &seafiletest.Data{
+		Libraries: []seafiletest.Library{
+			{
+				Name:    "Docs",
+				Owner:   "alice@example.com",
+				Commits: []seafiletest.Commit{commitAt(0, map[string]string{"shared/a.txt": "a"})},
+			},
+			{Name: "Shared", Owner: "bob@example.com", VirtualOf: "Docs", VirtualPath: "/shared"},
+		},
+	}
+	s := openTestStorage(t, d)
+
+	r, err := s.OpenRepo(d.Libraries[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// paths are relative to the shared folder
+	versions, err := r.FileHistory("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].Commit.CommitID != d.Libraries[1].Commits[0].ID {
+		t.Errorf("got versions %+v, want one from the virtual repo's commit", versions)
+	}
+}
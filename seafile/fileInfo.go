@@ -40,6 +40,10 @@ func (i *FileInfo) Mode() fs.FileMode {
 }
 
 func (i *FileInfo) ModTime() time.Time {
+	if i.d == nil {
+		return time.Time{}
+	}
+
 	return time.Unix(int64(i.d.MTime), 0)
 }
 
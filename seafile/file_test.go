@@ -0,0 +1,192 @@
+package seafile_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/thatoddmailbox/seafile-browse/seafile"
+	"github.com/thatoddmailbox/seafile-browse/seafile/seafiletest"
+)
+
+// blockTestContents are split into blocks of blockTestSize bytes, the last of which is shorter.
+const blockTestContents = "0123456789abcdefghijklmnopqrstuvwxyz"
+const blockTestSize = 8
+
+// openBlockTestFiles builds a plain and an encrypted library with blockTestContents split into blocks, and returns the
+// file from each.
+func openBlockTestFiles(t *testing.T) map[string]*seafile.File {
+	t.Helper()
+
+	d := &seafiletest.Data{
+		BlockSize: blockTestSize,
+		Libraries: []seafiletest.Library{
+			{
+				Name:    "Plain",
+				Commits: []seafiletest.Commit{commitAt(0, map[string]string{"f.txt": blockTestContents})},
+			},
+			{
+				Name:       "Encrypted",
+				Password:   "hunter2",
+				EncVersion: 3,
+				Commits:    []seafiletest.Commit{commitAt(0, map[string]string{"f.txt": blockTestContents})},
+			},
+		},
+	}
+	s := openTestStorage(t, d)
+
+	result := map[string]*seafile.File{}
+	for _, lib := range d.Libraries {
+		r, err := s.OpenRepo(lib.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lib.Password != "" {
+			err = r.Unlock(lib.Password)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		c, err := r.GetLatestCommit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sfs, err := c.GetFS()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := sfs.Open("f.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+
+		result[lib.Name] = f.(*seafile.File)
+	}
+	return result
+}
+
+func TestFileReader(t *testing.T) {
+	for name, f := range openBlockTestFiles(t) {
+		err := iotest.TestReader(f, []byte(blockTestContents))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestFileSeek(t *testing.T) {
+	for name, f := range openBlockTestFiles(t) {
+		// every offset, including each block boundary and the end of the file
+		for offset := int64(0); offset <= int64(len(blockTestContents)); offset++ {
+			for _, whence := range []int{io.SeekStart, io.SeekCurrent, io.SeekEnd} {
+				_, err := f.Seek(3, io.SeekStart)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				relative := offset
+				if whence == io.SeekCurrent {
+					relative = offset - 3
+				} else if whence == io.SeekEnd {
+					relative = offset - int64(len(blockTestContents))
+				}
+
+				got, err := f.Seek(relative, whence)
+				if err != nil || got != offset {
+					t.Errorf("%s: Seek(%d, %d) = %d, %v, want %d", name, relative, whence, got, err, offset)
+					continue
+				}
+
+				data, err := io.ReadAll(f)
+				if err != nil {
+					t.Errorf("%s: reading from %d: %v", name, offset, err)
+				}
+				if string(data) != blockTestContents[offset:] {
+					t.Errorf("%s: read %q from %d, want %q", name, data, offset, blockTestContents[offset:])
+				}
+			}
+		}
+
+		// a short read that ends exactly on a block boundary leaves the next read at the start of the next block
+		_, err := f.Seek(blockTestSize-2, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 2)
+		_, err = io.ReadFull(f, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.ReadFull(f, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != blockTestContents[blockTestSize:blockTestSize+2] {
+			t.Errorf("%s: read %q after a block boundary, want %q", name, b, blockTestContents[blockTestSize:blockTestSize+2])
+		}
+
+		_, err = f.Seek(-1, io.SeekStart)
+		if err == nil {
+			t.Errorf("%s: seeking before the start succeeded", name)
+		}
+	}
+}
+
+func TestFileReadAt(t *testing.T) {
+	for name, f := range openBlockTestFiles(t) {
+		size := len(blockTestContents)
+
+		// every range within the file, many of which span several blocks, and some that go past its end
+		for offset := 0; offset <= size; offset++ {
+			for length := 0; offset+length <= size+2; length++ {
+				b := make([]byte, length)
+				n, err := f.ReadAt(b, int64(offset))
+
+				end := offset + length
+				wantErr := error(nil)
+				if end > size {
+					end = size
+					wantErr = io.EOF
+				}
+				if offset == size && length == 0 {
+					// nothing can be read at the end
+					wantErr = io.EOF
+				}
+
+				if err != wantErr {
+					t.Errorf("%s: ReadAt(%d bytes, %d) returned %v, want %v", name, length, offset, err, wantErr)
+				}
+				if !bytes.Equal(b[:n], []byte(blockTestContents[offset:end])) {
+					t.Errorf("%s: ReadAt(%d bytes, %d) read %q, want %q", name, length, offset, b[:n], blockTestContents[offset:end])
+				}
+			}
+		}
+
+		// ReadAt doesn't move the offset that Read uses
+		_, err := f.Seek(blockTestSize, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.ReadAt(make([]byte, 20), 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 3)
+		_, err = io.ReadFull(f, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != blockTestContents[blockTestSize:blockTestSize+3] {
+			t.Errorf("%s: read %q after ReadAt, want %q", name, b, blockTestContents[blockTestSize:blockTestSize+3])
+		}
+
+		_, err = f.ReadAt(b, -1)
+		if err == nil {
+			t.Errorf("%s: reading before the start succeeded", name)
+		}
+	}
+}
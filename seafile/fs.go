@@ -1,6 +1,10 @@
 package seafile
 
-import "io/fs"
+import (
+	"io"
+	"io/fs"
+	"sort"
+)
 
 type FS struct {
 	c    *Commit
@@ -9,7 +13,86 @@ type FS struct {
 
 // Open opens the named file.
 func (sfsys *FS) Open(name string) (fs.File, error) {
-	return sfsys.root.open(name)
+	f, err := sfsys.root.open(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return f, nil
+}
+
+// Stat returns a FileInfo describing the named file.
+func (sfsys *FS) Stat(name string) (fs.FileInfo, error) {
+	f, err := sfsys.root.open(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	return f.Stat()
+}
+
+// ReadDir reads the named directory and returns its entries, sorted by filename.
+func (sfsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := sfsys.root.open(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	if f.i.Type != typeDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	result := make([]fs.DirEntry, len(f.i.Dirents))
+	for i := range f.i.Dirents {
+		result[i] = &DirEntry{
+			d: &f.i.Dirents[i],
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name() < result[j].Name()
+	})
+
+	return result, nil
+}
+
+// ReadFile reads the named file and returns its contents.
+func (sfsys *FS) ReadFile(name string) ([]byte, error) {
+	f, err := sfsys.root.open(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	defer f.Close()
+
+	if f.i.Type != typeFile {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+
+	result := make([]byte, f.d.Size)
+	_, err = io.ReadFull(f, result)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+
+	return result, nil
+}
+
+// Sub returns an FS rooted at the named directory. The directory is only looked up once, rather than on every call to
+// the new FS.
+func (sfsys *FS) Sub(dir string) (fs.FS, error) {
+	f, err := sfsys.root.open(dir)
+	if err != nil {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: err}
+	}
+
+	if f.i.Type != typeDir {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrInvalid}
+	}
+
+	return &FS{
+		c:    sfsys.c,
+		root: f,
+	}, nil
 }
 
 func newFS(c *Commit) (*FS, error) {
@@ -21,6 +104,7 @@ func newFS(c *Commit) (*FS, error) {
 	if err != nil {
 		return nil, err
 	}
+
 	sfsys.root = root
 
 	return &sfsys, nil
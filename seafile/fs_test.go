@@ -0,0 +1,232 @@
+package seafile_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/thatoddmailbox/seafile-browse/seafile"
+	"github.com/thatoddmailbox/seafile-browse/seafile/seafiletest"
+)
+
+// openTestFS builds a library with a few files and directories, including empty ones, and returns its latest FS.
+func openTestFS(t *testing.T) *seafile.FS {
+	t.Helper()
+
+	d := &seafiletest.Data{
+		BlockSize: 4,
+		Libraries: []seafiletest.Library{
+			{
+				Name:  "Docs",
+				Owner: "alice@example.com",
+				Commits: []seafiletest.Commit{
+					commitAt(0, map[string]string{
+						"a.txt":         "some text that spans a few blocks",
+						"empty.txt":     "",
+						"d/b.txt":       "b",
+						"d/e/c.txt":     "c",
+						"d/e/empty.txt": "",
+						"d/empty/":      "",
+						"z/":            "",
+					}),
+				},
+			},
+		},
+	}
+	s := openTestStorage(t, d)
+
+	r, err := s.OpenRepo(d.Libraries[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := r.GetLatestCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sfs, err := c.GetFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sfs
+}
+
+func TestFS(t *testing.T) {
+	sfs := openTestFS(t)
+
+	err := fstest.TestFS(sfs, "a.txt", "empty.txt", "d/b.txt", "d/e/c.txt", "d/e/empty.txt", "d/empty", "z")
+	if err != nil {
+		t.Error(err)
+	}
+
+	sub, err := sfs.Sub("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fstest.TestFS(sub, "b.txt", "e/c.txt", "e/empty.txt", "empty")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// fs.Sub uses the FS's own Sub
+	sub, err = fs.Sub(sfs, "d/e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fstest.TestFS(sub, "c.txt", "empty.txt")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFSOpenErrors(t *testing.T) {
+	sfs := openTestFS(t)
+
+	tests := []struct {
+		name string
+		want error
+	}{
+		{"missing.txt", fs.ErrNotExist},
+		{"d/missing/c.txt", fs.ErrNotExist},
+		{"/a.txt", fs.ErrInvalid},
+		{"a.txt/", fs.ErrInvalid},
+		{"d/../a.txt", fs.ErrInvalid},
+		{"./a.txt", fs.ErrInvalid},
+		{"", fs.ErrInvalid},
+	}
+	for _, test := range tests {
+		_, err := sfs.Open(test.name)
+
+		var pathErr *fs.PathError
+		if !errors.As(err, &pathErr) {
+			t.Errorf("Open(%q) returned %v, want a *fs.PathError", test.name, err)
+			continue
+		}
+		if pathErr.Op != "open" || pathErr.Path != test.name {
+			t.Errorf("Open(%q) returned %v, want it to be about opening %q", test.name, err, test.name)
+		}
+		if !errors.Is(err, test.want) {
+			t.Errorf("Open(%q) returned %v, want %v", test.name, err, test.want)
+		}
+	}
+
+	_, err := sfs.Sub("a.txt")
+	if !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Sub of a file returned %v, want fs.ErrInvalid", err)
+	}
+}
+
+func TestFileReadDirInParts(t *testing.T) {
+	sfs := openTestFS(t)
+
+	f, err := sfs.Open("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dir := f.(fs.ReadDirFile)
+
+	names := []string{}
+	for {
+		entries, err := dir.ReadDir(2)
+		if err == io.EOF {
+			if len(entries) != 0 {
+				t.Errorf("ReadDir returned %d entries along with io.EOF", len(entries))
+			}
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) == 0 || len(entries) > 2 {
+			t.Fatalf("ReadDir(2) returned %d entries", len(entries))
+		}
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) != 3 {
+		t.Errorf("reading d in parts gave %q, want its 3 entries", names)
+	}
+
+	// once everything has been read, there's nothing left, which isn't an error when asking for everything
+	entries, err := dir.ReadDir(-1)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("ReadDir(-1) at the end returned %d entries and %v, want none and no error", len(entries), err)
+	}
+
+	// a new File starts from the beginning again
+	f2, err := sfs.Open("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f2.Close()
+	entries, err = f2.(fs.ReadDirFile).ReadDir(-1)
+	if err != nil || len(entries) != 3 {
+		t.Errorf("ReadDir(-1) of a new File returned %d entries and %v, want 3", len(entries), err)
+	}
+}
+
+func TestFSRootStat(t *testing.T) {
+	sfs := openTestFS(t)
+
+	info, err := fs.Stat(sfs, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() {
+		t.Error("the root is not a directory")
+	}
+	// the root has no directory entry to take a modification time from
+	if !info.ModTime().IsZero() {
+		t.Errorf("the root has mtime %v, want none", info.ModTime())
+	}
+
+	// the root of a Sub does have one
+	sub, err := sfs.Sub("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = fs.Stat(sub, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() || !info.ModTime().Equal(testTime) {
+		t.Errorf("the root of Sub is dir %v with mtime %v, want a directory with mtime %v", info.IsDir(), info.ModTime(), testTime)
+	}
+}
+
+func TestFSEmptyFile(t *testing.T) {
+	sfs := openTestFS(t)
+
+	for _, name := range []string{"empty.txt", "d/e/empty.txt"} {
+		info, err := sfs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.IsDir() || info.Size() != 0 {
+			t.Errorf("Stat(%q) = dir %v, size %d, want an empty file", name, info.IsDir(), info.Size())
+		}
+
+		data, err := sfs.ReadFile(name)
+		if err != nil || len(data) != 0 {
+			t.Errorf("ReadFile(%q) = %q, %v, want nothing", name, data, err)
+		}
+
+		f, err := sfs.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := f.Read(make([]byte, 10))
+		if n != 0 || err != io.EOF {
+			t.Errorf("Read of %q = %d, %v, want 0, io.EOF", name, n, err)
+		}
+		f.Close()
+	}
+
+	// an empty directory also has the all-zero ID, and must not be mistaken for a file
+	entries, err := sfs.ReadDir("d/empty")
+	if err != nil || len(entries) != 0 {
+		t.Errorf("ReadDir of an empty directory returned %d entries and %v", len(entries), err)
+	}
+}
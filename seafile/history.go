@@ -0,0 +1,150 @@
+package seafile
+
+import (
+	"container/heap"
+	"errors"
+	"io/fs"
+	"time"
+)
+
+// ErrStopHistory can be returned from a HistoryFunc to end a walk early. WalkHistory does not return it as an error.
+var ErrStopHistory = errors.New("seafile: stop history")
+
+// ErrNoCommitAtTime is returned by FSAt when the Repo has no Commit at or before the given time.
+var ErrNoCommitAtTime = errors.New("seafile: no commit at or before the given time")
+
+// HistoryOptions restricts which Commits are visited by a history walk.
+type HistoryOptions struct {
+	// Since, if not zero, skips Commits made before this time.
+	Since time.Time
+
+	// Until, if not zero, skips Commits made after this time.
+	Until time.Time
+
+	// MaxCount, if greater than zero, is the maximum number of Commits visited.
+	MaxCount int
+}
+
+// HistoryFunc is called by WalkHistory for each Commit visited.
+type HistoryFunc func(c *Commit) error
+
+// commitQueue is a heap of Commits, with the newest on top.
+type commitQueue []*Commit
+
+func (q commitQueue) Len() int            { return len(q) }
+func (q commitQueue) Less(i, j int) bool  { return q[i].CTime > q[j].CTime }
+func (q commitQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x interface{}) { *q = append(*q, x.(*Commit)) }
+func (q *commitQueue) Pop() interface{} {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// WalkHistory walks the Repo's history, starting from the latest Commit and following the parents of each Commit,
+// including both parents of a merge. Commits are visited newest first, and each Commit is visited at most once.
+//
+// Parents that are missing from storage (for example, because they were removed by garbage collection) end that
+// line of history, rather than causing an error.
+func (r *Repo) WalkHistory(opts HistoryOptions, fn HistoryFunc) error {
+	head, err := r.GetLatestCommit()
+	if err != nil {
+		return err
+	}
+	if head == nil {
+		return nil
+	}
+
+	return r.walkHistoryFrom(head, opts, fn)
+}
+
+func (r *Repo) walkHistoryFrom(start *Commit, opts HistoryOptions, fn HistoryFunc) error {
+	seen := map[string]bool{start.CommitID: true}
+	queue := &commitQueue{start}
+	visited := 0
+
+	for queue.Len() > 0 {
+		c := heap.Pop(queue).(*Commit)
+
+		if !opts.Since.IsZero() && c.Time().Before(opts.Since) {
+			// everything left in the queue is older than this
+			break
+		}
+
+		if opts.Until.IsZero() || !c.Time().After(opts.Until) {
+			err := fn(c)
+			if err == ErrStopHistory {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			visited++
+			if opts.MaxCount > 0 && visited >= opts.MaxCount {
+				return nil
+			}
+		}
+
+		for _, parentID := range c.ParentIDs() {
+			if seen[parentID] {
+				continue
+			}
+			seen[parentID] = true
+
+			parent, err := r.openCommit(parentID)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			heap.Push(queue, parent)
+		}
+	}
+
+	return nil
+}
+
+// History returns the Commits visited by WalkHistory, newest first.
+func (r *Repo) History(opts HistoryOptions) ([]*Commit, error) {
+	result := []*Commit{}
+	err := r.WalkHistory(opts, func(c *Commit) error {
+		result = append(result, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// CommitAt returns the newest Commit made at or before the given time.
+func (r *Repo) CommitAt(t time.Time) (*Commit, error) {
+	var result *Commit
+	err := r.WalkHistory(HistoryOptions{Until: t, MaxCount: 1}, func(c *Commit) error {
+		result = c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if result == nil {
+		return nil, ErrNoCommitAtTime
+	}
+
+	return result, nil
+}
+
+// FSAt returns an FS of the Repo's state at the given time, which is the state at the newest Commit made at or
+// before it.
+func (r *Repo) FSAt(t time.Time) (*FS, error) {
+	c, err := r.CommitAt(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetFS()
+}
@@ -0,0 +1,260 @@
+package seafile_test
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/thatoddmailbox/seafile-browse/seafile"
+	"github.com/thatoddmailbox/seafile-browse/seafile/seafiletest"
+)
+
+// openHistoryTestRepo builds a library whose history has a merge: 1 and 3 are on one side, 2 on the other, and 4
+// merges them. It returns a function that opens the library with the given commits removed from storage.
+func openHistoryTestRepo(t *testing.T) (func(remove ...int) *seafile.Repo, *seafiletest.Library) {
+	t.Helper()
+
+	d := &seafiletest.Data{
+		Libraries: []seafiletest.Library{
+			{
+				Name:  "Docs",
+				Owner: "alice@example.com",
+				Commits: []seafiletest.Commit{
+					commitAt(0, map[string]string{"a.txt": "0"}),
+					commitAt(1, map[string]string{"a.txt": "1"}),
+					withParents(commitAt(2, map[string]string{"b.txt": "2"}), 0),
+					withParents(commitAt(3, map[string]string{"a.txt": "3"}), 1),
+					withParents(commitAt(4, map[string]string{"a.txt": "3", "b.txt": "2"}), 3, 2),
+					commitAt(5, map[string]string{"a.txt": "5", "b.txt": "2"}),
+				},
+			},
+		},
+	}
+	m := buildTestFS(t, d)
+	lib := &d.Libraries[0]
+
+	open := func(remove ...int) *seafile.Repo {
+		t.Helper()
+
+		fsys := m
+		if len(remove) > 0 {
+			fsys = fstest.MapFS{}
+			for p, f := range m {
+				fsys[p] = f
+			}
+			for _, i := range remove {
+				id := lib.Commits[i].ID
+				delete(fsys, path.Join("storage", "commits", lib.ID, id[:2], id[2:]))
+			}
+		}
+
+		s := seafile.NewStorageWithFS(fsys)
+		s.SetMetadataSource(d.Metadata())
+
+		r, err := s.OpenRepo(lib.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	return open, lib
+}
+
+func withParents(c seafiletest.Commit, parents ...int) seafiletest.Commit {
+	c.Parents = parents
+	return c
+}
+
+// commitIndexes gives the index of each of the given Commits in the library.
+func commitIndexes(lib *seafiletest.Library, commits []*seafile.Commit) []int {
+	index := map[string]int{}
+	for i, c := range lib.Commits {
+		index[c.ID] = i
+	}
+
+	result := []int{}
+	for _, c := range commits {
+		i, exists := index[c.CommitID]
+		if !exists {
+			i = -1
+		}
+		result = append(result, i)
+	}
+	return result
+}
+
+func TestHistory(t *testing.T) {
+	open, lib := openHistoryTestRepo(t)
+	r := open()
+
+	tests := []struct {
+		name string
+		opts seafile.HistoryOptions
+		want []int
+	}{
+		{
+			name: "everything, newest first, across the merge",
+			want: []int{5, 4, 3, 2, 1, 0},
+		},
+		{
+			name: "max count",
+			opts: seafile.HistoryOptions{MaxCount: 2},
+			want: []int{5, 4},
+		},
+		{
+			name: "since a commit's time includes it",
+			opts: seafile.HistoryOptions{Since: testTime.Add(2 * time.Hour)},
+			want: []int{5, 4, 3, 2},
+		},
+		{
+			name: "until a commit's time includes it",
+			opts: seafile.HistoryOptions{Until: testTime.Add(3 * time.Hour)},
+			want: []int{3, 2, 1, 0},
+		},
+		{
+			name: "since and until",
+			opts: seafile.HistoryOptions{Since: testTime.Add(30 * time.Minute), Until: testTime.Add(150 * time.Minute)},
+			want: []int{2, 1},
+		},
+		{
+			name: "until and max count",
+			opts: seafile.HistoryOptions{Until: testTime.Add(4 * time.Hour), MaxCount: 3},
+			want: []int{4, 3, 2},
+		},
+		{
+			name: "until before the first commit",
+			opts: seafile.HistoryOptions{Until: testTime.Add(-time.Hour)},
+			want: []int{},
+		},
+		{
+			name: "since after the last commit",
+			opts: seafile.HistoryOptions{Since: testTime.Add(6 * time.Hour)},
+			want: []int{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			history, err := r.History(test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := commitIndexes(lib, history)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got commits %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWalkHistoryStop(t *testing.T) {
+	open, lib := openHistoryTestRepo(t)
+	r := open()
+
+	visited := []*seafile.Commit{}
+	err := r.WalkHistory(seafile.HistoryOptions{}, func(c *seafile.Commit) error {
+		visited = append(visited, c)
+		if c.CommitID == lib.Commits[3].ID {
+			return seafile.ErrStopHistory
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkHistory returned %v when stopped, want nil", err)
+	}
+	if got, want := commitIndexes(lib, visited), []int{5, 4, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("visited commits %v, want %v", got, want)
+	}
+
+	// any other error ends the walk and is returned
+	errTest := errors.New("test error")
+	visited = visited[:0]
+	err = r.WalkHistory(seafile.HistoryOptions{}, func(c *seafile.Commit) error {
+		visited = append(visited, c)
+		return errTest
+	})
+	if err != errTest {
+		t.Errorf("WalkHistory returned %v, want the callback's error", err)
+	}
+	if len(visited) != 1 {
+		t.Errorf("visited %d commits after an error, want 1", len(visited))
+	}
+}
+
+func TestHistoryMissingParent(t *testing.T) {
+	// without 1, the side of the merge it was on ends at 3, while the other side carries on to 0
+	open, lib := openHistoryTestRepo(t)
+	r := open(1)
+
+	history, err := r.History(seafile.HistoryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := commitIndexes(lib, history), []int{5, 4, 3, 2, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got commits %v, want %v", got, want)
+	}
+}
+
+func TestCommitAt(t *testing.T) {
+	open, lib := openHistoryTestRepo(t)
+	r := open()
+
+	tests := []struct {
+		name  string
+		at    time.Time
+		want  int
+		files map[string]string
+	}{
+		{"at the first commit", testTime, 0, map[string]string{"a.txt": "0"}},
+		{"between two commits", testTime.Add(150 * time.Minute), 2, map[string]string{"b.txt": "2"}},
+		{"just before a commit", testTime.Add(3*time.Hour - time.Nanosecond), 2, map[string]string{"b.txt": "2"}},
+		{"exactly at a commit", testTime.Add(3 * time.Hour), 3, map[string]string{"a.txt": "3"}},
+		{"after the last commit", testTime.Add(24 * time.Hour), 5, map[string]string{"a.txt": "5", "b.txt": "2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := r.CommitAt(test.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := commitIndexes(lib, []*seafile.Commit{c}); got[0] != test.want {
+				t.Errorf("CommitAt(%v) is commit %d, want %d", test.at, got[0], test.want)
+			}
+
+			sfs, err := r.FSAt(test.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			for _, name := range []string{"a.txt", "b.txt"} {
+				data, err := sfs.ReadFile(name)
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				got[name] = string(data)
+			}
+			if !reflect.DeepEqual(got, test.files) {
+				t.Errorf("FSAt(%v) has files %v, want %v", test.at, got, test.files)
+			}
+		})
+	}
+
+	before := testTime.Add(-time.Second)
+	_, err := r.CommitAt(before)
+	if err != seafile.ErrNoCommitAtTime {
+		t.Errorf("CommitAt before the first commit returned %v, want ErrNoCommitAtTime", err)
+	}
+	_, err = r.FSAt(before)
+	if err != seafile.ErrNoCommitAtTime {
+		t.Errorf("FSAt before the first commit returned %v, want ErrNoCommitAtTime", err)
+	}
+}
@@ -0,0 +1,38 @@
+package seafile
+
+import (
+	"bytes"
+	"io/fs"
+	"time"
+)
+
+// memoryFile is an fs.File holding data that has already been read, such as a decrypted or verified block.
+type memoryFile struct {
+	info fs.FileInfo
+	*bytes.Reader
+}
+
+type memoryFileInfo struct {
+	name string
+	size int64
+}
+
+func (i *memoryFileInfo) Name() string       { return i.name }
+func (i *memoryFileInfo) Size() int64        { return i.size }
+func (i *memoryFileInfo) Mode() fs.FileMode  { return 0 }
+func (i *memoryFileInfo) ModTime() time.Time { return time.Time{} }
+func (i *memoryFileInfo) IsDir() bool        { return false }
+func (i *memoryFileInfo) Sys() interface{}   { return nil }
+
+func (f *memoryFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memoryFile) Close() error               { return nil }
+
+func newMemoryFile(name string, data []byte) *memoryFile {
+	return &memoryFile{
+		info: &memoryFileInfo{
+			name: name,
+			size: int64(len(data)),
+		},
+		Reader: bytes.NewReader(data),
+	}
+}
@@ -0,0 +1,176 @@
+package seafile
+
+import (
+	"io/fs"
+)
+
+// MetadataSource provides what Seafile keeps about repos in its database rather than in storage, such as their names,
+// owners and branch heads. Implementations must be safe to use from multiple goroutines.
+type MetadataSource interface {
+	// Metadata returns the current metadata. The result must not be modified afterwards, by either the caller or the
+	// source, so a source that changes should return a new Metadata each time it does, and the same one while it
+	// doesn't, so that callers can tell by comparing pointers.
+	Metadata() (*Metadata, error)
+}
+
+// Metadata describes the repos of a Seafile server. It can be read from the seafile_db database with ReadSQLDump or
+// ReadSQLite, or filled in some other way, such as from JSON. A Metadata is a MetadataSource that always returns
+// itself.
+type Metadata struct {
+	// BranchHeads maps the ID of each repo to the ID of the latest Commit on its master branch.
+	BranchHeads map[string]string `json:"branch_heads"`
+
+	// Repos maps the ID of each repo to what is known about it.
+	Repos map[string]RepoMetadata `json:"repos"`
+
+	Shares []Share `json:"shares"`
+}
+
+// RepoMetadata is what a Metadata knows about a single repo.
+type RepoMetadata struct {
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+
+	// Garbage is set for repos that have been deleted, but not yet removed by the garbage collector.
+	Garbage bool `json:"garbage"`
+
+	// Virtual is set for repos that share a folder of another repo, given by OriginRepoID and OriginPath.
+	Virtual      bool   `json:"virtual"`
+	OriginRepoID string `json:"origin_repo_id"`
+	OriginPath   string `json:"origin_path"`
+}
+
+// Share is a repo that has been shared with a user or a group.
+type Share struct {
+	RepoID string `json:"repo_id"`
+
+	// From is the user who shared the repo.
+	From string `json:"from"`
+
+	// Only one of ToUser and ToGroupID is set.
+	ToUser    string `json:"to_user"`
+	ToGroupID string `json:"to_group_id"`
+
+	// Permission is "r" for read-only or "rw" for read-write, as Seafile stores it.
+	Permission string `json:"permission"`
+}
+
+func (m *Metadata) Metadata() (*Metadata, error) {
+	return m, nil
+}
+
+// storeID returns the ID that the fs objects and blocks of the repo with the given ID are stored under. Virtual repos
+// keep their own commits, but store everything else with their origin.
+func (m *Metadata) storeID(repoID string) string {
+	repo := m.Repos[repoID]
+	if repo.Virtual && repo.OriginRepoID != "" {
+		return repo.OriginRepoID
+	}
+
+	return repoID
+}
+
+// seafileTableColumns lists the columns of the seafile_db tables that Metadata is read from, as created by Seafile.
+// These are only used if a dump does not have its own CREATE TABLE statements.
+var seafileTableColumns = map[string][]string{
+	"Branch":       {"id", "name", "repo_id", "commit_id"},
+	"GarbageRepos": {"id", "repo_id"},
+	"RepoGroup":    {"id", "group_id", "user_name", "repo_id", "permission"},
+	"RepoInfo":     {"id", "repo_id", "name", "update_time", "version", "is_encrypted", "last_modifier", "status"},
+	"RepoOwner":    {"id", "repo_id", "owner_id"},
+	"SharedRepo":   {"id", "repo_id", "from_email", "to_email", "permission"},
+	"VirtualRepo":  {"id", "repo_id", "origin_repo", "path", "base_commit"},
+}
+
+func newMetadata() *Metadata {
+	return &Metadata{
+		BranchHeads: map[string]string{},
+		Repos:       map[string]RepoMetadata{},
+		Shares:      []Share{},
+	}
+}
+
+// addRow records a row of one of the tables in seafileTableColumns.
+func (m *Metadata) addRow(table string, row sqlRow) error {
+	repoID, ok := row.get("repo_id")
+	if !ok {
+		return nil
+	}
+
+	repo := m.Repos[repoID]
+
+	switch table {
+	case "Branch":
+		branch, _ := row.get("name")
+		if branch != "master" {
+			// something weird, ignore it
+			return nil
+		}
+
+		m.BranchHeads[repoID], _ = row.get("commit_id")
+		return nil
+	case "RepoInfo":
+		repo.Name, _ = row.get("name")
+	case "RepoOwner":
+		repo.Owner, _ = row.get("owner_id")
+	case "VirtualRepo":
+		repo.Virtual = true
+		repo.OriginRepoID, _ = row.get("origin_repo")
+		repo.OriginPath, _ = row.get("path")
+	case "GarbageRepos":
+		repo.Garbage = true
+	case "SharedRepo":
+		share := Share{RepoID: repoID}
+		share.From, _ = row.get("from_email")
+		share.ToUser, _ = row.get("to_email")
+		share.Permission, _ = row.get("permission")
+		m.Shares = append(m.Shares, share)
+		return nil
+	case "RepoGroup":
+		share := Share{RepoID: repoID}
+		share.From, _ = row.get("user_name")
+		share.ToGroupID, _ = row.get("group_id")
+		share.Permission, _ = row.get("permission")
+		m.Shares = append(m.Shares, share)
+		return nil
+	}
+
+	m.Repos[repoID] = repo
+	return nil
+}
+
+// ReadSQLDump reads the Metadata from the SQL file at the given path, which should be a dump of the seafile_db
+// database made by mysqldump or, in its plain format, pg_dump.
+func ReadSQLDump(fsys fs.FS, sqlPath string) (*Metadata, error) {
+	sqlFile, err := fsys.Open(sqlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer sqlFile.Close()
+
+	m := newMetadata()
+	err = parseSQLDump(sqlFile, seafileTableColumns, m.addRow)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// ReadSQLite reads the Metadata from the seafile.db SQLite database at the given path, which Seafile uses when it is
+// not set up with MySQL or PostgreSQL. Changes still in the database's write-ahead log are included.
+func ReadSQLite(fsys fs.FS, sqlitePath string) (*Metadata, error) {
+	db, err := openSQLite(fsys, sqlitePath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	m := newMetadata()
+	err = db.readTables(seafileTableColumns, m.addRow)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
@@ -0,0 +1,225 @@
+package seafile
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+)
+
+// Orphans lists the objects stored for a repo that are not used by any Commit reachable from a branch head, which are
+// what Seafile's garbage collector would remove.
+type Orphans struct {
+	// RepoID is the ID the objects are stored under. Virtual repos store their objects under their origin repo.
+	RepoID string
+
+	FSObjects     []string
+	FSObjectsSize int64
+
+	Blocks     []string
+	BlocksSize int64
+}
+
+// referenced is the set of objects in a single store that are in use.
+type referenced struct {
+	fsObjects map[string]bool
+	blocks    map[string]bool
+}
+
+func listDirNames(fsys fs.FS, dir string) ([]string, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			result = append(result, entry.Name())
+		}
+	}
+	return result, nil
+}
+
+func (ref *referenced) markTree(r *Repo, id string, isDir bool) error {
+	if id == emptyID || ref.fsObjects[id] {
+		return nil
+	}
+	ref.fsObjects[id] = true
+
+	i, err := r.readFSObject(id)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if !isDir {
+		for _, blockID := range i.BlockIDs {
+			ref.blocks[blockID] = true
+		}
+		return nil
+	}
+
+	for _, dirent := range i.Dirents {
+		err = ref.markTree(r, dirent.ID, direntIsDir(&dirent))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findUnreferenced lists the objects of the given kind in a store that are not in the referenced set.
+func (s *Storage) findUnreferenced(kind string, storeID string, referenced map[string]bool) ([]string, int64, error) {
+	ids := []string{}
+	var totalSize int64
+
+	storePath := path.Join("storage", kind, storeID)
+	err := fs.WalkDir(s.fsys, storePath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		id := path.Base(path.Dir(p)) + d.Name()
+		if referenced[id] {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		ids = append(ids, id)
+		totalSize += info.Size()
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return ids, totalSize, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	sort.Strings(ids)
+	return ids, totalSize, nil
+}
+
+// FindOrphans walks every Commit reachable from the branch head of every repo that has not been deleted, then lists
+// the fs objects and blocks in storage that none of them use. Nothing is modified.
+//
+// Branch heads come from the MetadataSource if there is one, or are otherwise the latest Commit of each repo.
+func (s *Storage) FindOrphans() ([]*Orphans, error) {
+	repoIDs, err := s.ListRepoIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := s.Metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	refs := map[string]*referenced{}
+	for _, repoID := range repoIDs {
+		if m.Repos[repoID].Garbage {
+			continue
+		}
+
+		storeID := m.storeID(repoID)
+		ref, exists := refs[storeID]
+		if !exists {
+			ref = &referenced{
+				fsObjects: map[string]bool{},
+				blocks:    map[string]bool{},
+			}
+			refs[storeID] = ref
+		}
+
+		// commits of virtual repos are stored under their own ID
+		r := newRepo(repoID, s.fsys, s)
+		r.storeID = storeID
+
+		var head *Commit
+		if s.metadataSource != nil {
+			headID := m.BranchHeads[repoID]
+			if headID == "" {
+				// not a live repo
+				continue
+			}
+
+			head, err = r.openCommit(headID)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+		} else {
+			head, err = r.GetLatestCommit()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if head == nil {
+			continue
+		}
+
+		err = r.walkHistoryFrom(head, HistoryOptions{}, func(c *Commit) error {
+			return ref.markTree(r, c.RootID, true)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fsStores, err := listDirNames(s.fsys, "storage/fs")
+	if err != nil {
+		return nil, err
+	}
+	blockStores, err := listDirNames(s.fsys, "storage/blocks")
+	if err != nil {
+		return nil, err
+	}
+
+	storeIDs := map[string]bool{}
+	for _, storeID := range append(fsStores, blockStores...) {
+		storeIDs[storeID] = true
+	}
+
+	result := []*Orphans{}
+	for storeID := range storeIDs {
+		ref := refs[storeID]
+		if ref == nil {
+			ref = &referenced{}
+		}
+
+		orphans := Orphans{
+			RepoID: storeID,
+		}
+
+		orphans.FSObjects, orphans.FSObjectsSize, err = s.findUnreferenced("fs", storeID, ref.fsObjects)
+		if err != nil {
+			return nil, err
+		}
+
+		orphans.Blocks, orphans.BlocksSize, err = s.findUnreferenced("blocks", storeID, ref.blocks)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(orphans.FSObjects) > 0 || len(orphans.Blocks) > 0 {
+			result = append(result, &orphans)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].FSObjectsSize+result[i].BlocksSize > result[j].FSObjectsSize+result[j].BlocksSize
+	})
+
+	return result, nil
+}
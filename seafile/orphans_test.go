@@ -0,0 +1,175 @@
+package seafile_test
+
+import (
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+	"testing/fstest"
+
+	"github.com/thatoddmailbox/seafile-browse/seafile"
+	"github.com/thatoddmailbox/seafile-browse/seafile/seafiletest"
+)
+
+// findOrphans returns the orphaned fs objects and blocks found in m, by repo ID.
+func findOrphans(t *testing.T, m fstest.MapFS, source seafile.MetadataSource) map[string]*seafile.Orphans {
+	t.Helper()
+
+	s := seafile.NewStorageWithFS(m)
+	if source != nil {
+		s.SetMetadataSource(source)
+	}
+
+	orphans, err := s.FindOrphans()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := map[string]*seafile.Orphans{}
+	for _, o := range orphans {
+		result[o.RepoID] = o
+	}
+	return result
+}
+
+func objectIDs(paths []string) []string {
+	result := []string{}
+	for _, p := range paths {
+		result = append(result, objectID(p))
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestFindOrphansNone(t *testing.T) {
+	d := &seafiletest.Data{
+		Libraries: []seafiletest.Library{
+			{
+				Name: "Docs",
+				Commits: []seafiletest.Commit{
+					commitAt(0, map[string]string{"shared/a.txt": "a", "b.txt": "b"}),
+					// the old versions are still in the history
+					commitAt(1, map[string]string{"shared/a.txt": "a2"}),
+				},
+			},
+			{Name: "Shared", VirtualOf: "Docs", VirtualPath: "/shared"},
+		},
+	}
+	m := buildTestFS(t, d)
+
+	for _, source := range []seafile.MetadataSource{nil, d.Metadata()} {
+		orphans := findOrphans(t, m, source)
+		if len(orphans) != 0 {
+			t.Errorf("found orphans %+v, want none", orphans)
+		}
+	}
+}
+
+func TestFindOrphans(t *testing.T) {
+	d := &seafiletest.Data{
+		Libraries: []seafiletest.Library{
+			{
+				Name: "Docs",
+				Commits: []seafiletest.Commit{
+					commitAt(0, map[string]string{"a.txt": "a"}),
+					commitAt(1, map[string]string{"a.txt": "a, after the head"}),
+				},
+			},
+			{
+				Name:    "Deleted",
+				Garbage: true,
+				Commits: []seafiletest.Commit{commitAt(0, map[string]string{"d/b.txt": "b"})},
+			},
+		},
+	}
+	m := buildTestFS(t, d)
+	docs := d.Libraries[0]
+	deleted := d.Libraries[1]
+
+	// a block that nothing refers to, as left behind by an interrupted upload
+	stray := "0123456789abcdef0123456789abcdef01234567"
+	m[path.Join("storage", "blocks", docs.ID, stray[:2], stray[2:])] = &fstest.MapFile{Data: []byte("stray")}
+
+	// the branch head is the first commit, so the second one isn't reachable, as after reverting a library
+	metadata := d.Metadata()
+	metadata.BranchHeads[docs.ID] = docs.Commits[0].ID
+
+	orphans := findOrphans(t, m, metadata)
+	if len(orphans) != 2 {
+		t.Fatalf("found orphans for %d repos, want 2", len(orphans))
+	}
+
+	// everything stored for the deleted library
+	o := orphans[deleted.ID]
+	if o == nil {
+		t.Fatal("found no orphans for the deleted library")
+	}
+	if !reflect.DeepEqual(o.FSObjects, objectIDs(storedObjects(m, "fs", deleted.ID))) {
+		t.Errorf("deleted library has orphaned fs objects %v, want all of them", o.FSObjects)
+	}
+	if !reflect.DeepEqual(o.Blocks, objectIDs(storedObjects(m, "blocks", deleted.ID))) {
+		t.Errorf("deleted library has orphaned blocks %v, want all of them", o.Blocks)
+	}
+	if o.BlocksSize != 1 {
+		t.Errorf("deleted library has %d bytes of orphaned blocks, want 1", o.BlocksSize)
+	}
+
+	// the stray block, and what only the commit after the head uses
+	o = orphans[docs.ID]
+	if o == nil {
+		t.Fatal("found no orphans for Docs")
+	}
+	if len(o.FSObjects) != 2 {
+		t.Errorf("Docs has orphaned fs objects %v, want the root and file of the second commit", o.FSObjects)
+	}
+	if len(o.Blocks) != 2 {
+		t.Fatalf("Docs has orphaned blocks %v, want the stray one and that of the second commit", o.Blocks)
+	}
+	wantSize := int64(len("stray") + len("a, after the head"))
+	if o.BlocksSize != wantSize {
+		t.Errorf("Docs has %d bytes of orphaned blocks, want %d", o.BlocksSize, wantSize)
+	}
+
+	// without metadata, the latest commit is taken to be the head
+	orphans = findOrphans(t, m, nil)
+	if o := orphans[docs.ID]; o == nil || len(o.FSObjects) != 0 || !reflect.DeepEqual(o.Blocks, []string{stray}) {
+		t.Errorf("without metadata, found orphans %+v for Docs, want only the stray block", o)
+	}
+	if o := orphans[deleted.ID]; o != nil {
+		t.Errorf("without metadata, found orphans %+v for the deleted library, which isn't known to be deleted", o)
+	}
+}
+
+func TestFindOrphansVirtualRepo(t *testing.T) {
+	d := &seafiletest.Data{
+		Libraries: []seafiletest.Library{
+			{
+				Name: "Docs",
+				Commits: []seafiletest.Commit{
+					commitAt(0, map[string]string{"shared/a.txt": "a"}),
+				},
+			},
+			{Name: "Shared", VirtualOf: "Docs", VirtualPath: "/shared"},
+		},
+	}
+	m := buildTestFS(t, d)
+	docs := d.Libraries[0]
+
+	// nothing can be reached from the origin's head, as it's missing, but the virtual repo still uses the shared folder
+	metadata := d.Metadata()
+	metadata.BranchHeads[docs.ID] = "0000000000000000000000000000000000000001"
+
+	orphans := findOrphans(t, m, metadata)
+	o := orphans[docs.ID]
+	if o == nil {
+		t.Fatal("found no orphans")
+	}
+
+	// only the origin's root is unused, as the shared folder and its file are the virtual repo's
+	if len(o.FSObjects) != 1 || len(o.Blocks) != 0 {
+		t.Errorf("found orphans %+v, want only the origin's root", o)
+	}
+	if _, exists := orphans[d.Libraries[1].ID]; exists {
+		t.Error("found orphans stored under the virtual repo's own ID")
+	}
+}
@@ -1,32 +1,67 @@
 package seafile
 
 import (
+	"errors"
+	"fmt"
 	"io/fs"
 	"path"
+	"strings"
 )
 
+// ErrInvalidCommitID is returned by GetCommit when given something that cannot be a commit ID or prefix.
+var ErrInvalidCommitID = errors.New("seafile: invalid commit ID")
+
+const commitIDLength = 40
+const minCommitIDPrefixLength = 4
+
+// AmbiguousCommitError is returned by GetCommit when an abbreviated commit ID matches more than one Commit.
+type AmbiguousCommitError struct {
+	Prefix  string
+	Matches []string
+}
+
+func (e *AmbiguousCommitError) Error() string {
+	return fmt.Sprintf("seafile: commit ID %s is ambiguous (matches %d commits)", e.Prefix, len(e.Matches))
+}
+
 type Repo struct {
 	id   string
 	fsys fs.FS
 	s    *Storage
+
+	// storeID is the repo that fs objects and blocks are stored under, which for a virtual repo is its origin
+	storeID string
+
+	// garbage is set when the repo has been deleted, and was opened with OpenGarbageRepo
+	garbage bool
+
+	crypt *blockCrypt
+}
+
+// IsGarbage returns whether the Repo has been deleted.
+func (r *Repo) IsGarbage() bool {
+	return r.garbage
+}
+
+// IsVirtual returns whether the Repo is a virtual repo, which has its own Commits, but reads its files from the
+// storage of its origin repo.
+func (r *Repo) IsVirtual() bool {
+	return r.storeID != r.id
 }
 
 // GetLatestCommit returns the most recent Commit to the Repo.
 func (r *Repo) GetLatestCommit() (*Commit, error) {
 	commitPath := path.Join("storage", "commits", r.id)
 
-	if r.s.haveOptimization {
-		lastCommit := r.s.latestCommits[r.id]
+	if r.s.metadataSource != nil {
+		m, err := r.s.Metadata()
+		if err != nil {
+			return nil, err
+		}
 
+		lastCommit := m.BranchHeads[r.id]
 		if lastCommit != "" {
-			path := lastCommit[:2] + "/" + lastCommit[2:]
-
-			f, err := r.fsys.Open(commitPath + "/" + path)
-			if err != nil {
-				return nil, err
-			}
-
-			commit, err := newCommit(r.id, r.fsys, f)
+			commit, err := r.openCommit(lastCommit)
 			if err == nil {
 				return commit, nil
 			}
@@ -48,7 +83,7 @@ func (r *Repo) GetLatestCommit() (*Commit, error) {
 			return err
 		}
 
-		commit, err := newCommit(r.id, r.fsys, f)
+		commit, err := newCommit(r, f)
 		if err != nil {
 			return err
 		}
@@ -66,10 +101,70 @@ func (r *Repo) GetLatestCommit() (*Commit, error) {
 	return latestCommit, nil
 }
 
+// GetCommit returns the Commit with the given ID. The ID may be abbreviated to a unique prefix of at least 4
+// characters; if more than one Commit matches, an *AmbiguousCommitError is returned.
+func (r *Repo) GetCommit(id string) (*Commit, error) {
+	id = strings.ToLower(id)
+
+	if len(id) < minCommitIDPrefixLength || len(id) > commitIDLength {
+		return nil, ErrInvalidCommitID
+	}
+	for _, c := range id {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return nil, ErrInvalidCommitID
+		}
+	}
+
+	if len(id) == commitIDLength {
+		return r.openCommit(id)
+	}
+
+	entries, err := fs.ReadDir(r.fsys, path.Join("storage", "commits", r.id, id[:2]))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fs.ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+
+	matches := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), id[2:]) {
+			matches = append(matches, id[:2]+entry.Name())
+		}
+	}
+
+	if len(matches) == 0 {
+		return nil, fs.ErrNotExist
+	}
+	if len(matches) > 1 {
+		return nil, &AmbiguousCommitError{
+			Prefix:  id,
+			Matches: matches,
+		}
+	}
+
+	return r.openCommit(matches[0])
+}
+
+func (r *Repo) openCommit(commitID string) (*Commit, error) {
+	if len(commitID) < 3 {
+		return nil, fs.ErrNotExist
+	}
+
+	f, err := r.fsys.Open(path.Join("storage", "commits", r.id, commitID[:2], commitID[2:]))
+	if err != nil {
+		return nil, err
+	}
+
+	return newCommit(r, f)
+}
+
 func newRepo(id string, fsys fs.FS, s *Storage) *Repo {
 	return &Repo{
 		id:   id,
 		fsys: fsys,
 		s:    s,
+
+		storeID: id,
 	}
 }
@@ -0,0 +1,106 @@
+package seafile_test
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/thatoddmailbox/seafile-browse/seafile"
+	"github.com/thatoddmailbox/seafile-browse/seafile/seafiletest"
+)
+
+func TestGetCommit(t *testing.T) {
+	d := &seafiletest.Data{
+		Libraries: []seafiletest.Library{
+			{
+				Name: "Docs",
+				Commits: []seafiletest.Commit{
+					commitAt(0, map[string]string{"a.txt": "0"}),
+					commitAt(1, map[string]string{"a.txt": "1"}),
+				},
+			},
+		},
+	}
+	m := buildTestFS(t, d)
+	lib := d.Libraries[0]
+	first := lib.Commits[0].ID
+	second := lib.Commits[1].ID
+
+	// a copy of the first commit, with an ID that shares its first 10 characters
+	twin := first[:10] + strings.Repeat("0", 30)
+	if twin == first {
+		twin = first[:10] + strings.Repeat("1", 30)
+	}
+	firstPath := path.Join("storage", "commits", lib.ID, first[:2], first[2:])
+	m[path.Join("storage", "commits", lib.ID, twin[:2], twin[2:])] = &fstest.MapFile{
+		Data: []byte(strings.Replace(string(m[firstPath].Data), first, twin, 1)),
+	}
+
+	s := seafile.NewStorageWithFS(m)
+	s.SetMetadataSource(d.Metadata())
+	r, err := s.OpenRepo(lib.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := []struct {
+		id   string
+		want string
+	}{
+		{second, second},
+		{strings.ToUpper(second), second},
+		{second[:4], second},
+		{second[:12], second},
+		{first[:11], first},
+		{twin[:11], twin},
+		{twin, twin},
+	}
+	for _, test := range found {
+		c, err := r.GetCommit(test.id)
+		if err != nil {
+			t.Errorf("GetCommit(%q): %v", test.id, err)
+			continue
+		}
+		if c.CommitID != test.want {
+			t.Errorf("GetCommit(%q) = %s, want %s", test.id, c.CommitID, test.want)
+		}
+	}
+
+	for _, prefix := range []string{first[:4], first[:10]} {
+		_, err := r.GetCommit(prefix)
+
+		var ambiguous *seafile.AmbiguousCommitError
+		if !errors.As(err, &ambiguous) {
+			t.Errorf("GetCommit(%q) returned %v, want an *AmbiguousCommitError", prefix, err)
+			continue
+		}
+
+		sort.Strings(ambiguous.Matches)
+		want := []string{first, twin}
+		sort.Strings(want)
+		if ambiguous.Prefix != prefix || !reflect.DeepEqual(ambiguous.Matches, want) {
+			t.Errorf("GetCommit(%q) is ambiguous between %v for %q, want %v", prefix, ambiguous.Matches, ambiguous.Prefix, want)
+		}
+	}
+
+	invalid := []string{"", "abc", second[:3], "ghij", second[:8] + "-", second + "0", "../" + second[3:]}
+	for _, id := range invalid {
+		_, err := r.GetCommit(id)
+		if err != seafile.ErrInvalidCommitID {
+			t.Errorf("GetCommit(%q) returned %v, want ErrInvalidCommitID", id, err)
+		}
+	}
+
+	missing := []string{strings.Repeat("f", 40), second[:2] + "ffff", "ffff"}
+	for _, id := range missing {
+		_, err := r.GetCommit(id)
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("GetCommit(%q) returned %v, want fs.ErrNotExist", id, err)
+		}
+	}
+}
@@ -0,0 +1,647 @@
+// Package seafiletest builds Seafile storage trees for use in tests, from a simple description of libraries, their
+// files and their commit history.
+package seafiletest
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing/fstest"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// EmptyID is the object ID Seafile uses for empty files and directories.
+const EmptyID = "0000000000000000000000000000000000000000"
+
+const modeFile = 0100644
+const modeDir = 040000
+
+// Data describes the contents of a Seafile data directory.
+type Data struct {
+	Libraries []Library
+
+	// BlockSize is the size that file contents are split into blocks at. If it is 0, each file is a single block.
+	BlockSize int
+
+	// SQLFile, if set, is the path at which a mysqldump of the libraries' metadata is written, as read by
+	// seafile.Storage.ParseSQLFile.
+	SQLFile string
+}
+
+// Library describes a single library.
+type Library struct {
+	// ID is the library's ID. If it is empty, one is generated from the Name, and set by MapFS or WriteDir.
+	ID    string
+	Name  string
+	Owner string
+
+	// Commits are the library's commits, oldest first.
+	Commits []Commit
+
+	// Password, if set, makes this an encrypted library with the given EncVersion, which defaults to 2.
+	Password   string
+	EncVersion int
+
+	// Garbage marks the library as deleted.
+	Garbage bool
+
+	// VirtualOf, if set, is the ID or Name of the library that this virtual library shares the folder VirtualPath of.
+	// It is replaced with the ID by MapFS or WriteDir. A virtual library has no Commits of its own; one is generated
+	// from the latest commit of the origin.
+	VirtualOf   string
+	VirtualPath string
+}
+
+// Commit describes the state of a library after a commit.
+type Commit struct {
+	// ID is set by MapFS or WriteDir.
+	ID string
+
+	Time        time.Time
+	Description string
+	Creator     string
+
+	// Parents are the indexes of this commit's parents in Library.Commits. If it is nil, the parent is the previous
+	// commit. Two parents make a merge commit.
+	Parents []int
+
+	// Files maps paths to file contents. A path ending in a slash is an empty directory. Files that are the same as in
+	// the first parent keep their modification time and modifier, as they would in Seafile. So do files at a new path
+	// with the same contents as one in the first parent, which are taken to have been moved.
+	Files map[string]string
+}
+
+// encryption holds the keys of an encrypted library.
+type encryption struct {
+	version   int
+	salt      []byte
+	magic     string
+	randomKey string
+	key       []byte
+	iv        []byte
+}
+
+type builder struct {
+	d *Data
+	m fstest.MapFS
+
+	// heads maps library IDs to the root ID of their latest commit, for virtual libraries to share
+	heads map[string]string
+}
+
+// MapFS builds the described data directory in memory. Paths in the result are relative to the data directory, so
+// it can be used with seafile.NewStorageWithFS.
+func (d *Data) MapFS() (fstest.MapFS, error) {
+	b := builder{
+		d:     d,
+		m:     fstest.MapFS{},
+		heads: map[string]string{},
+	}
+
+	for i := range d.Libraries {
+		lib := &d.Libraries[i]
+		if lib.ID == "" {
+			lib.ID = libraryID(lib.Name)
+		}
+	}
+
+	for i := range d.Libraries {
+		if d.Libraries[i].VirtualOf != "" {
+			continue
+		}
+
+		err := b.buildLibrary(&d.Libraries[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range d.Libraries {
+		if d.Libraries[i].VirtualOf == "" {
+			continue
+		}
+
+		err := b.buildVirtualLibrary(&d.Libraries[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if d.SQLFile != "" {
+		b.m[d.SQLFile] = &fstest.MapFile{
+			Data: []byte(d.sqlDump()),
+			Mode: 0644,
+		}
+	}
+
+	return b.m, nil
+}
+
+// WriteDir builds the described data directory in the given directory on disk.
+func (d *Data) WriteDir(dir string) error {
+	m, err := d.MapFS()
+	if err != nil {
+		return err
+	}
+
+	for name, file := range m {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+
+		err = os.MkdirAll(filepath.Dir(p), 0755)
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(p, file.Data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// libraryID makes up a stable, UUID-formatted ID from a library name.
+func libraryID(name string) string {
+	sum := sha1.Sum([]byte("library:" + name))
+	h := hex.EncodeToString(sum[:16])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+func sha1Hex(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func objectPath(kind string, repoID string, id string) string {
+	return path.Join("storage", kind, repoID, id[:2], id[2:])
+}
+
+func (b *builder) putObject(kind string, repoID string, id string, data []byte) {
+	b.m[objectPath(kind, repoID, id)] = &fstest.MapFile{
+		Data: data,
+		Mode: 0644,
+	}
+}
+
+func (b *builder) putFSObject(repoID string, object interface{}) (string, error) {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return "", err
+	}
+
+	id := sha1Hex(data)
+
+	compressed := bytes.Buffer{}
+	w := zlib.NewWriter(&compressed)
+	_, err = w.Write(data)
+	if err != nil {
+		return "", err
+	}
+	err = w.Close()
+	if err != nil {
+		return "", err
+	}
+
+	b.putObject("fs", repoID, id, compressed.Bytes())
+	return id, nil
+}
+
+func (b *builder) putFile(repoID string, contents string, enc *encryption) (string, error) {
+	if contents == "" {
+		return EmptyID, nil
+	}
+
+	blockSize := b.d.BlockSize
+	if blockSize <= 0 {
+		blockSize = len(contents)
+	}
+
+	blockIDs := []string{}
+	for start := 0; start < len(contents); start += blockSize {
+		end := start + blockSize
+		if end > len(contents) {
+			end = len(contents)
+		}
+
+		block := []byte(contents[start:end])
+		if enc != nil {
+			var err error
+			block, err = encryptCBC(block, enc.key, enc.iv)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		blockID := sha1Hex(block)
+		b.putObject("blocks", repoID, blockID, block)
+		blockIDs = append(blockIDs, blockID)
+	}
+
+	return b.putFSObject(repoID, map[string]interface{}{
+		"block_ids": blockIDs,
+		"size":      len(contents),
+		"type":      1,
+		"version":   1,
+	})
+}
+
+// tree is a directory being built up from a Commit's Files.
+type tree struct {
+	files map[string]string
+	dirs  map[string]*tree
+}
+
+func newTree() *tree {
+	return &tree{
+		files: map[string]string{},
+		dirs:  map[string]*tree{},
+	}
+}
+
+func (t *tree) add(p string, contents string) error {
+	isDir := strings.HasSuffix(p, "/")
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+
+	current := t
+	for i, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("seafiletest: invalid path %q", p)
+		}
+
+		if i == len(parts)-1 && !isDir {
+			current.files[part] = contents
+			break
+		}
+
+		next, exists := current.dirs[part]
+		if !exists {
+			next = newTree()
+			current.dirs[part] = next
+		}
+		current = next
+	}
+
+	return nil
+}
+
+// stamp is what a file or empty directory in a commit was last changed by. Unchanged entries keep the stamp they had
+// in the first parent commit, as in Seafile, so that unchanged subtrees keep their object IDs.
+type stamp struct {
+	contents string
+	mtime    int64
+	modifier string
+}
+
+// findStamp returns the stamp that the file at the given path had in the first parent, if it is unchanged. A file at
+// a new path with the same contents as one in the parent is taken to have been moved there, and keeps its stamp too.
+func findStamp(prev map[string]stamp, p string, contents string) (stamp, bool) {
+	st, ok := prev[p]
+	if ok {
+		return st, st.contents == contents
+	}
+
+	// the first path in order, so that the result doesn't depend on map order
+	foundPath := ""
+	for otherPath, other := range prev {
+		if other.contents == contents && !strings.HasSuffix(otherPath, "/") && (foundPath == "" || otherPath < foundPath) {
+			foundPath = otherPath
+		}
+	}
+	if foundPath == "" {
+		return stamp{}, false
+	}
+	return prev[foundPath], true
+}
+
+type dirent struct {
+	ID       string `json:"id"`
+	Mode     int    `json:"mode"`
+	Modifier string `json:"modifier"`
+	MTime    int64  `json:"mtime"`
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+}
+
+// putTree stores the directory at dirPath and everything in it, and returns its ID and modification time. The stamps
+// of the first parent commit are in prev, and those of this commit are added to cur.
+func (b *builder) putTree(repoID string, t *tree, dirPath string, c *Commit, enc *encryption, prev map[string]stamp, cur map[string]stamp) (string, int64, error) {
+	dirents := []dirent{}
+	mtime := int64(0)
+
+	for name, contents := range t.files {
+		id, err := b.putFile(repoID, contents, enc)
+		if err != nil {
+			return "", 0, err
+		}
+
+		p := path.Join(dirPath, name)
+		st, ok := findStamp(prev, p, contents)
+		if !ok {
+			st = stamp{contents, c.Time.Unix(), c.Creator}
+		}
+		cur[p] = st
+
+		dirents = append(dirents, dirent{
+			ID:       id,
+			Mode:     modeFile,
+			Modifier: st.modifier,
+			MTime:    st.mtime,
+			Name:     name,
+			Size:     int64(len(contents)),
+		})
+		if st.mtime > mtime {
+			mtime = st.mtime
+		}
+	}
+
+	for name, sub := range t.dirs {
+		id, subMTime, err := b.putTree(repoID, sub, path.Join(dirPath, name), c, enc, prev, cur)
+		if err != nil {
+			return "", 0, err
+		}
+
+		dirents = append(dirents, dirent{
+			ID:    id,
+			Mode:  modeDir,
+			MTime: subMTime,
+			Name:  name,
+		})
+		if subMTime > mtime {
+			mtime = subMTime
+		}
+	}
+
+	if len(dirents) == 0 {
+		st, ok := prev[dirPath+"/"]
+		if !ok {
+			st = stamp{mtime: c.Time.Unix()}
+		}
+		cur[dirPath+"/"] = st
+
+		return EmptyID, st.mtime, nil
+	}
+
+	// seafile keeps dirents in descending order of name
+	sort.Slice(dirents, func(i, j int) bool {
+		return dirents[i].Name > dirents[j].Name
+	})
+
+	id, err := b.putFSObject(repoID, map[string]interface{}{
+		"dirents": dirents,
+		"type":    3,
+		"version": 1,
+	})
+	return id, mtime, err
+}
+
+func (b *builder) putCommit(repoID string, object map[string]interface{}) (string, error) {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return "", err
+	}
+
+	id := sha1Hex(data)
+	object["commit_id"] = id
+
+	data, err = json.Marshal(object)
+	if err != nil {
+		return "", err
+	}
+
+	b.putObject("commits", repoID, id, data)
+	return id, nil
+}
+
+func (b *builder) buildLibrary(lib *Library) error {
+	var enc *encryption
+	if lib.Password != "" {
+		var err error
+		enc, err = newEncryption(lib)
+		if err != nil {
+			return err
+		}
+	}
+
+	stamps := make([]map[string]stamp, len(lib.Commits))
+	for i := range lib.Commits {
+		c := &lib.Commits[i]
+
+		t := newTree()
+		for p, contents := range c.Files {
+			err := t.add(p, contents)
+			if err != nil {
+				return err
+			}
+		}
+
+		parents := c.Parents
+		if parents == nil && i > 0 {
+			parents = []int{i - 1}
+		}
+		if len(parents) > 2 {
+			return errors.New("seafiletest: a commit can have at most two parents")
+		}
+
+		var prev map[string]stamp
+		if len(parents) > 0 && parents[0] >= 0 && parents[0] < i {
+			prev = stamps[parents[0]]
+		}
+		stamps[i] = map[string]stamp{}
+
+		rootID, _, err := b.putTree(lib.ID, t, "", c, enc, prev, stamps[i])
+		if err != nil {
+			return err
+		}
+
+		object := map[string]interface{}{
+			"root_id":          rootID,
+			"repo_id":          lib.ID,
+			"creator_name":     c.Creator,
+			"creator":          "0000000000000000000000000000000000000000",
+			"description":      c.Description,
+			"ctime":            c.Time.Unix(),
+			"parent_id":        nil,
+			"second_parent_id": nil,
+			"repo_name":        lib.Name,
+			"repo_desc":        "",
+			"repo_category":    nil,
+			"version":          1,
+		}
+		for j, parent := range parents {
+			if parent < 0 || parent >= i {
+				return fmt.Errorf("seafiletest: commit %d of %s has invalid parent %d", i, lib.Name, parent)
+			}
+
+			key := "parent_id"
+			if j == 1 {
+				key = "second_parent_id"
+			}
+			object[key] = lib.Commits[parent].ID
+		}
+		if enc != nil {
+			object["encrypted"] = "true"
+			object["enc_version"] = enc.version
+			object["magic"] = enc.magic
+			object["key"] = enc.randomKey
+			if enc.version >= 3 {
+				object["salt"] = hex.EncodeToString(enc.salt)
+			}
+		}
+
+		c.ID, err = b.putCommit(lib.ID, object)
+		if err != nil {
+			return err
+		}
+
+		b.heads[lib.ID] = rootID
+	}
+
+	return nil
+}
+
+func (b *builder) buildVirtualLibrary(lib *Library) error {
+	var origin *Library
+	for i := range b.d.Libraries {
+		if b.d.Libraries[i].ID == lib.VirtualOf || b.d.Libraries[i].Name == lib.VirtualOf {
+			origin = &b.d.Libraries[i]
+		}
+	}
+	if origin == nil || origin.VirtualOf != "" || len(origin.Commits) == 0 {
+		return fmt.Errorf("seafiletest: virtual library %s has no origin with commits", lib.Name)
+	}
+	lib.VirtualOf = origin.ID
+
+	// find the shared folder in the latest commit of the origin
+	rootID := b.heads[origin.ID]
+	for _, part := range strings.Split(strings.Trim(lib.VirtualPath, "/"), "/") {
+		if part == "" {
+			continue
+		}
+
+		found := false
+		if rootID != EmptyID {
+			var dir struct {
+				Dirents []dirent `json:"dirents"`
+			}
+			r, err := zlib.NewReader(bytes.NewReader(b.m[objectPath("fs", origin.ID, rootID)].Data))
+			if err != nil {
+				return err
+			}
+			err = json.NewDecoder(r).Decode(&dir)
+			if err != nil {
+				return err
+			}
+
+			for _, d := range dir.Dirents {
+				if d.Name == part && d.Mode == modeDir {
+					rootID = d.ID
+					found = true
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("seafiletest: virtual library %s shares missing folder %s", lib.Name, lib.VirtualPath)
+		}
+	}
+
+	head := origin.Commits[len(origin.Commits)-1]
+	id, err := b.putCommit(lib.ID, map[string]interface{}{
+		"root_id":          rootID,
+		"repo_id":          lib.ID,
+		"creator_name":     head.Creator,
+		"creator":          "0000000000000000000000000000000000000000",
+		"description":      "Created virtual library",
+		"ctime":            head.Time.Unix(),
+		"parent_id":        nil,
+		"second_parent_id": nil,
+		"repo_name":        lib.Name,
+		"repo_desc":        "",
+		"repo_category":    nil,
+		"version":          1,
+	})
+	if err != nil {
+		return err
+	}
+
+	lib.Commits = []Commit{
+		{
+			ID:          id,
+			Time:        head.Time,
+			Description: "Created virtual library",
+			Creator:     head.Creator,
+		},
+	}
+
+	return nil
+}
+
+func deriveKey(data []byte, salt []byte) ([]byte, []byte) {
+	key := pbkdf2.Key(data, salt, 1000, 32, sha256.New)
+	iv := pbkdf2.Key(key, salt, 10, 16, sha256.New)
+	return key, iv
+}
+
+func encryptCBC(data []byte, key []byte, iv []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	padded := append(append([]byte{}, data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	result := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(result, padded)
+	return result, nil
+}
+
+func newEncryption(lib *Library) (*encryption, error) {
+	enc := encryption{
+		version: lib.EncVersion,
+	}
+	if enc.version == 0 {
+		enc.version = 2
+	}
+
+	switch enc.version {
+	case 2:
+		enc.salt = []byte{0xda, 0x90, 0x45, 0xc3, 0x06, 0xc7, 0xcc, 0x26}
+	case 3, 4:
+		sum := sha256.Sum256([]byte("salt:" + lib.ID))
+		enc.salt = sum[:]
+	default:
+		return nil, fmt.Errorf("seafiletest: unsupported encryption version %d", enc.version)
+	}
+
+	// the random key is not really random, so that the output is reproducible
+	randomKey := sha256.Sum256([]byte("key:" + lib.ID))
+
+	passwordKey, passwordIV := deriveKey([]byte(lib.Password), enc.salt)
+	encryptedRandomKey, err := encryptCBC(randomKey[:], passwordKey, passwordIV)
+	if err != nil {
+		return nil, err
+	}
+	enc.randomKey = hex.EncodeToString(encryptedRandomKey)
+
+	magic, _ := deriveKey([]byte(lib.ID+lib.Password), enc.salt)
+	enc.magic = hex.EncodeToString(magic)
+
+	enc.key, enc.iv = deriveKey(randomKey[:], enc.salt)
+
+	return &enc, nil
+}
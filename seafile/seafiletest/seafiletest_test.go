@@ -0,0 +1,195 @@
+package seafiletest_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/thatoddmailbox/seafile-browse/seafile"
+	"github.com/thatoddmailbox/seafile-browse/seafile/seafiletest"
+)
+
+var start = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func testData() *seafiletest.Data {
+	return &seafiletest.Data{
+		BlockSize: 4,
+		SQLFile:   "seafile_db.sql",
+		Libraries: []seafiletest.Library{
+			{
+				Name:  "Docs",
+				Owner: "alice@example.com",
+				Commits: []seafiletest.Commit{
+					{Time: start, Creator: "alice@example.com", Files: map[string]string{
+						"a/x.txt": "first version",
+						"b/y.txt": "unchanged",
+						"empty/":  "",
+					}},
+					{Time: start.Add(time.Hour), Creator: "bob@example.com", Files: map[string]string{
+						"a/x.txt": "second version",
+						"b/y.txt": "unchanged",
+						"empty/":  "",
+					}},
+				},
+			},
+			{
+				Name:       "Secret",
+				Owner:      "bob@example.com",
+				Password:   "hunter2",
+				EncVersion: 3,
+				Commits: []seafiletest.Commit{
+					{Time: start, Files: map[string]string{"s.txt": "a secret spanning blocks"}},
+				},
+			},
+			{
+				Name:    "Old",
+				Owner:   "carol@example.com",
+				Garbage: true,
+				Commits: []seafiletest.Commit{
+					{Time: start, Files: map[string]string{"o.txt": "old"}},
+				},
+			},
+			{
+				Name:        "Shared",
+				Owner:       "dave@example.com",
+				VirtualOf:   "Docs",
+				VirtualPath: "/b",
+			},
+		},
+	}
+}
+
+func TestSQLDumpMatchesMetadata(t *testing.T) {
+	d := testData()
+	fsys, err := d.MapFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := seafile.ReadSQLDump(fsys, d.SQLFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := d.Metadata(); !reflect.DeepEqual(m, want) {
+		t.Errorf("SQL dump gives\n%+v\nbut Metadata is\n%+v", m, want)
+	}
+}
+
+func TestReadBack(t *testing.T) {
+	d := testData()
+	fsys, err := d.MapFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := seafile.NewStorageWithFS(fsys)
+	err = s.ParseSQLFile(d.SQLFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		library  int
+		password string
+		files    map[string]string
+	}{
+		{0, "", map[string]string{"a/x.txt": "second version", "b/y.txt": "unchanged"}},
+		{1, "hunter2", map[string]string{"s.txt": "a secret spanning blocks"}},
+		{2, "", map[string]string{"o.txt": "old"}},
+		{3, "", map[string]string{"y.txt": "unchanged"}},
+	}
+	for _, test := range tests {
+		lib := d.Libraries[test.library]
+
+		r, err := s.OpenGarbageRepo(lib.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.password != "" {
+			err = r.Unlock(test.password)
+			if err != nil {
+				t.Fatalf("%s: %v", lib.Name, err)
+			}
+		}
+
+		head, err := r.GetLatestCommit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if head.CommitID != lib.Commits[len(lib.Commits)-1].ID {
+			t.Errorf("%s: head is %s, want %s", lib.Name, head.CommitID, lib.Commits[len(lib.Commits)-1].ID)
+		}
+
+		sfs, err := head.GetFS()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, want := range test.files {
+			data, err := sfs.ReadFile(name)
+			if err != nil {
+				t.Errorf("%s: ReadFile(%q): %v", lib.Name, name, err)
+			} else if string(data) != want {
+				t.Errorf("%s: ReadFile(%q) = %q, want %q", lib.Name, name, data, want)
+			}
+		}
+	}
+}
+
+func TestUnchangedFilesKeepMTime(t *testing.T) {
+	d := testData()
+	fsys, err := d.MapFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := seafile.NewStorageWithFS(fsys)
+	s.SetMetadataSource(d.Metadata())
+
+	r, err := s.OpenRepo(d.Libraries[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := r.GetLatestCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sfs, err := head.GetFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]time.Time{
+		"a/x.txt": start.Add(time.Hour),
+		"a":       start.Add(time.Hour),
+		"b/y.txt": start,
+		"b":       start,
+	} {
+		info, err := sfs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(want) {
+			t.Errorf("%s was modified at %v, want %v", name, info.ModTime(), want)
+		}
+	}
+
+	// nothing under b changed, so the second commit doesn't touch it at all
+	changes, err := seafile.Diff(mustCommit(t, r, d.Libraries[0].Commits[0].ID), head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Path != "a/x.txt" {
+		t.Errorf("changes are %+v, want only a/x.txt", changes)
+	}
+}
+
+func mustCommit(t *testing.T, r *seafile.Repo, id string) *seafile.Commit {
+	t.Helper()
+
+	c, err := r.GetCommit(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
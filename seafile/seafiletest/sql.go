@@ -0,0 +1,198 @@
+package seafiletest
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/thatoddmailbox/seafile-browse/seafile"
+)
+
+// table describes a table in the SQL dump, along with the CREATE TABLE columns that mysqldump would write for it.
+type table struct {
+	name    string
+	columns []string
+	rows    [][]interface{}
+}
+
+var sqlEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"'", "\\'",
+	"\"", "\\\"",
+	"\n", "\\n",
+	"\r", "\\r",
+	"\x00", "\\0",
+	"\x1a", "\\Z",
+)
+
+func sqlValue(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + sqlEscaper.Replace(value) + "'"
+	case bool:
+		if value {
+			return "1"
+		}
+		return "0"
+	}
+
+	return fmt.Sprint(v)
+}
+
+func (t *table) write(b *strings.Builder) {
+	fmt.Fprintf(b, "DROP TABLE IF EXISTS `%s`;\n", t.name)
+	fmt.Fprintf(b, "CREATE TABLE `%s` (\n", t.name)
+	for _, column := range t.columns {
+		fmt.Fprintf(b, "  %s,\n", column)
+	}
+	fmt.Fprintf(b, "  PRIMARY KEY (`id`)\n) ENGINE=InnoDB DEFAULT CHARSET=utf8;\n\n")
+
+	if len(t.rows) == 0 {
+		return
+	}
+
+	fmt.Fprintf(b, "LOCK TABLES `%s` WRITE;\n", t.name)
+	fmt.Fprintf(b, "INSERT INTO `%s` VALUES ", t.name)
+	for i, row := range t.rows {
+		if i > 0 {
+			b.WriteString(",")
+		}
+
+		values := []string{}
+		for _, v := range row {
+			values = append(values, sqlValue(v))
+		}
+		fmt.Fprintf(b, "(%s)", strings.Join(values, ","))
+	}
+	fmt.Fprintf(b, ";\nUNLOCK TABLES;\n\n")
+}
+
+// sqlDump returns a dump of the seafile_db tables that describe the libraries, in the format written by mysqldump.
+func (d *Data) sqlDump() string {
+	repo := table{
+		name: "Repo",
+		columns: []string{
+			"`id` bigint(20) NOT NULL AUTO_INCREMENT",
+			"`repo_id` char(37) DEFAULT NULL",
+		},
+	}
+	branch := table{
+		name: "Branch",
+		columns: []string{
+			"`id` bigint(20) NOT NULL AUTO_INCREMENT",
+			"`name` varchar(10) DEFAULT NULL",
+			"`repo_id` char(41) DEFAULT NULL",
+			"`commit_id` char(41) DEFAULT NULL",
+		},
+	}
+	repoInfo := table{
+		name: "RepoInfo",
+		columns: []string{
+			"`id` bigint(20) NOT NULL AUTO_INCREMENT",
+			"`repo_id` char(36) DEFAULT NULL",
+			"`name` varchar(255) NOT NULL",
+			"`update_time` bigint(20) DEFAULT NULL",
+			"`version` int(11) DEFAULT NULL",
+			"`is_encrypted` int(11) DEFAULT NULL",
+			"`last_modifier` varchar(255) DEFAULT NULL",
+			"`status` int(11) DEFAULT '0'",
+		},
+	}
+	repoOwner := table{
+		name: "RepoOwner",
+		columns: []string{
+			"`id` bigint(20) NOT NULL AUTO_INCREMENT",
+			"`repo_id` char(37) DEFAULT NULL",
+			"`owner_id` varchar(255) DEFAULT NULL",
+		},
+	}
+	virtualRepo := table{
+		name: "VirtualRepo",
+		columns: []string{
+			"`id` bigint(20) NOT NULL AUTO_INCREMENT",
+			"`repo_id` char(36) DEFAULT NULL",
+			"`origin_repo` char(36) DEFAULT NULL",
+			"`path` text",
+			"`base_commit` char(40) DEFAULT NULL",
+		},
+	}
+	garbageRepos := table{
+		name: "GarbageRepos",
+		columns: []string{
+			"`id` bigint(20) NOT NULL AUTO_INCREMENT",
+			"`repo_id` char(36) DEFAULT NULL",
+		},
+	}
+
+	for i, lib := range d.Libraries {
+		id := i + 1
+
+		if lib.Garbage {
+			garbageRepos.rows = append(garbageRepos.rows, []interface{}{id, lib.ID})
+		} else {
+			repo.rows = append(repo.rows, []interface{}{id, lib.ID})
+
+			if len(lib.Commits) > 0 {
+				head := lib.Commits[len(lib.Commits)-1]
+				branch.rows = append(branch.rows, []interface{}{id, "master", lib.ID, head.ID})
+			}
+		}
+
+		var updateTime interface{}
+		lastModifier := ""
+		if len(lib.Commits) > 0 {
+			head := lib.Commits[len(lib.Commits)-1]
+			updateTime = head.Time.Unix()
+			lastModifier = head.Creator
+		}
+		repoInfo.rows = append(repoInfo.rows, []interface{}{id, lib.ID, lib.Name, updateTime, 1, lib.Password != "", lastModifier, 0})
+
+		repoOwner.rows = append(repoOwner.rows, []interface{}{id, lib.ID, lib.Owner})
+
+		if lib.VirtualOf != "" {
+			var baseCommit interface{}
+			if len(lib.Commits) > 0 {
+				baseCommit = lib.Commits[0].ID
+			}
+			virtualRepo.rows = append(virtualRepo.rows, []interface{}{id, lib.ID, lib.VirtualOf, lib.VirtualPath, baseCommit})
+		}
+	}
+
+	b := strings.Builder{}
+	b.WriteString("-- MySQL dump 10.13\n--\n-- Host: localhost    Database: seafile_db\n\n")
+	for _, t := range []*table{&branch, &garbageRepos, &repo, &repoInfo, &repoOwner, &virtualRepo} {
+		t.write(&b)
+	}
+	b.WriteString("-- Dump completed\n")
+
+	return b.String()
+}
+
+// Metadata returns the same metadata as the SQL file, for use with seafile.Storage.SetMetadataSource. It must be
+// called after MapFS or WriteDir, which fill in the IDs of the libraries and their commits.
+func (d *Data) Metadata() *seafile.Metadata {
+	m := &seafile.Metadata{
+		BranchHeads: map[string]string{},
+		Repos:       map[string]seafile.RepoMetadata{},
+		Shares:      []seafile.Share{},
+	}
+
+	for _, lib := range d.Libraries {
+		if !lib.Garbage && len(lib.Commits) > 0 {
+			m.BranchHeads[lib.ID] = lib.Commits[len(lib.Commits)-1].ID
+		}
+
+		m.Repos[lib.ID] = seafile.RepoMetadata{
+			Name:    lib.Name,
+			Owner:   lib.Owner,
+			Garbage: lib.Garbage,
+
+			Virtual:      lib.VirtualOf != "",
+			OriginRepoID: lib.VirtualOf,
+			OriginPath:   lib.VirtualPath,
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,988 @@
+package seafile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type sqlTokenKind int
+
+const (
+	sqlTokenEOF sqlTokenKind = iota
+
+	// sqlTokenWord is a keyword, unquoted identifier, number or NULL.
+	sqlTokenWord
+
+	// sqlTokenIdent is an identifier quoted with backticks.
+	sqlTokenIdent
+
+	sqlTokenString
+
+	// sqlTokenPunct is any other single character, such as a parenthesis, comma or semicolon.
+	sqlTokenPunct
+)
+
+type sqlToken struct {
+	kind sqlTokenKind
+	text string
+	line int
+}
+
+func (t sqlToken) is(kind sqlTokenKind, text string) bool {
+	if t.kind != kind {
+		return false
+	}
+
+	if kind == sqlTokenWord {
+		return strings.EqualFold(t.text, text)
+	}
+
+	return t.text == text
+}
+
+// isName returns whether the token could be the name of a table or column. SQLite also allows names in double quotes,
+// which are otherwise strings.
+func (t sqlToken) isName() bool {
+	return t.kind == sqlTokenWord || t.kind == sqlTokenIdent || t.kind == sqlTokenString
+}
+
+// sqlTokenizer splits the output of mysqldump or pg_dump into tokens, skipping whitespace and comments.
+type sqlTokenizer struct {
+	r    *bufio.Reader
+	line int
+
+	// standardStrings is set for PostgreSQL's standard_conforming_strings, where a backslash in a string is only a
+	// backslash, unless the string is written as E'...'.
+	standardStrings bool
+}
+
+func isSQLWordByte(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') || b == '_' || b == '$' || b >= 0x80
+}
+
+func (t *sqlTokenizer) readByte() (byte, error) {
+	b, err := t.r.ReadByte()
+	if err == nil && b == '\n' {
+		t.line++
+	}
+	return b, err
+}
+
+func (t *sqlTokenizer) unreadByte(b byte) {
+	t.r.UnreadByte()
+	if b == '\n' {
+		t.line--
+	}
+}
+
+func (t *sqlTokenizer) peekByte() (byte, bool) {
+	next, err := t.r.Peek(1)
+	if err != nil {
+		return 0, false
+	}
+
+	return next[0], true
+}
+
+func (t *sqlTokenizer) skipLine() error {
+	for {
+		b, err := t.readByte()
+		if err != nil || b == '\n' {
+			return err
+		}
+	}
+}
+
+// readLine reads the rest of the current line, without its line ending.
+func (t *sqlTokenizer) readLine() (string, error) {
+	line, err := t.r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		// the last line of the file
+		return line, nil
+	} else if err != nil {
+		return line, err
+	}
+
+	t.line++
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+func (t *sqlTokenizer) skipBlockComment() error {
+	prev := byte(0)
+	for {
+		b, err := t.readByte()
+		if err == io.EOF {
+			return fmt.Errorf("seafile: line %d: unterminated comment", t.line)
+		} else if err != nil {
+			return err
+		}
+
+		if prev == '*' && b == '/' {
+			return nil
+		}
+		prev = b
+	}
+}
+
+// readQuoted reads the rest of a string or identifier that started with the given quote. Backslash escapes are only
+// read if backslashEscapes is set.
+func (t *sqlTokenizer) readQuoted(quote byte, backslashEscapes bool) (string, error) {
+	startLine := t.line
+	value := strings.Builder{}
+	for {
+		b, err := t.readByte()
+		if err == io.EOF {
+			return "", fmt.Errorf("seafile: line %d: unterminated quoted string", startLine)
+		} else if err != nil {
+			return "", err
+		}
+
+		if b == quote {
+			// a doubled quote stands for the quote itself
+			next, ok := t.peekByte()
+			if ok && next == quote {
+				t.readByte()
+				value.WriteByte(quote)
+				continue
+			}
+
+			return value.String(), nil
+		}
+
+		if b == '\\' && backslashEscapes {
+			b, err = t.readByte()
+			if err == io.EOF {
+				return "", fmt.Errorf("seafile: line %d: unterminated quoted string", startLine)
+			} else if err != nil {
+				return "", err
+			}
+
+			switch b {
+			case '0':
+				value.WriteByte(0)
+			case 'b':
+				value.WriteByte('\b')
+			case 'n':
+				value.WriteByte('\n')
+			case 'r':
+				value.WriteByte('\r')
+			case 't':
+				value.WriteByte('\t')
+			case 'Z':
+				value.WriteByte(0x1a)
+			case '%', '_':
+				// kept as is, as MySQL does
+				value.WriteByte('\\')
+				value.WriteByte(b)
+			default:
+				value.WriteByte(b)
+			}
+			continue
+		}
+
+		value.WriteByte(b)
+	}
+}
+
+func (t *sqlTokenizer) next() (sqlToken, error) {
+	for {
+		b, err := t.readByte()
+		if err == io.EOF {
+			return sqlToken{kind: sqlTokenEOF, line: t.line}, nil
+		} else if err != nil {
+			return sqlToken{}, err
+		}
+
+		line := t.line
+
+		switch {
+		case b == ' ' || b == '\t' || b == '\n' || b == '\r':
+			continue
+
+		case b == '#':
+			err = t.skipLine()
+			if err != nil && err != io.EOF {
+				return sqlToken{}, err
+			}
+			continue
+
+		case b == '-':
+			// "--" only starts a comment if it is followed by whitespace
+			next, err := t.r.Peek(2)
+			if len(next) >= 1 && next[0] == '-' && (len(next) == 1 || next[1] == ' ' || next[1] == '\t' || next[1] == '\n' || next[1] == '\r') {
+				err = t.skipLine()
+				if err != nil && err != io.EOF {
+					return sqlToken{}, err
+				}
+				continue
+			} else if err != nil && err != io.EOF {
+				return sqlToken{}, err
+			}
+
+		case b == '/':
+			next, ok := t.peekByte()
+			if ok && next == '*' {
+				// this includes version comments like /*!40101 SET NAMES utf8 */, which are only settings
+				t.readByte()
+				err = t.skipBlockComment()
+				if err != nil {
+					return sqlToken{}, err
+				}
+				continue
+			}
+
+		case b == '\'':
+			value, err := t.readQuoted(b, !t.standardStrings)
+			if err != nil {
+				return sqlToken{}, err
+			}
+			return sqlToken{kind: sqlTokenString, text: value, line: line}, nil
+
+		case b == '"':
+			// an identifier in PostgreSQL, but only ever a string in the places mysqldump uses it
+			value, err := t.readQuoted(b, !t.standardStrings)
+			if err != nil {
+				return sqlToken{}, err
+			}
+			return sqlToken{kind: sqlTokenString, text: value, line: line}, nil
+
+		case b == '`':
+			value, err := t.readQuoted(b, false)
+			if err != nil {
+				return sqlToken{}, err
+			}
+			return sqlToken{kind: sqlTokenIdent, text: value, line: line}, nil
+
+		case isSQLWordByte(b):
+			word := []byte{b}
+			for {
+				b, err = t.readByte()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return sqlToken{}, err
+				}
+
+				if !isSQLWordByte(b) {
+					t.unreadByte(b)
+					break
+				}
+				word = append(word, b)
+			}
+
+			next, ok := t.peekByte()
+			if ok && next == '\'' && (string(word) == "E" || string(word) == "e") {
+				// a PostgreSQL string with backslash escapes
+				t.readByte()
+				value, err := t.readQuoted('\'', true)
+				if err != nil {
+					return sqlToken{}, err
+				}
+				return sqlToken{kind: sqlTokenString, text: value, line: line}, nil
+			}
+
+			return sqlToken{kind: sqlTokenWord, text: string(word), line: line}, nil
+		}
+
+		return sqlToken{kind: sqlTokenPunct, text: string(b), line: line}, nil
+	}
+}
+
+// sqlValue is a single value from an INSERT statement.
+type sqlValue struct {
+	text string
+	null bool
+}
+
+// sqlRow maps the column names of a table to the values of a single row.
+type sqlRow map[string]sqlValue
+
+// get returns the value of the given column, and false if it is NULL or missing.
+func (r sqlRow) get(column string) (string, bool) {
+	value, ok := r[column]
+	if !ok || value.null {
+		return "", false
+	}
+
+	return value.text, true
+}
+
+// sqlDumpParser reads the rows inserted into some tables by a mysqldump or pg_dump file. Other statements are
+// skipped.
+type sqlDumpParser struct {
+	t sqlTokenizer
+
+	// columns maps each table that should be read to its column names, in order.
+	columns map[string][]string
+
+	peeked *sqlToken
+}
+
+// wantedTable returns the name that the given table was asked for with, and whether it was. Names are matched without
+// regard to case, since PostgreSQL folds unquoted names to lower case.
+func (p *sqlDumpParser) wantedTable(table string) (string, bool) {
+	if _, ok := p.columns[table]; ok {
+		return table, true
+	}
+
+	for wantedName := range p.columns {
+		if strings.EqualFold(wantedName, table) {
+			return wantedName, true
+		}
+	}
+
+	return "", false
+}
+
+func (p *sqlDumpParser) next() (sqlToken, error) {
+	if p.peeked != nil {
+		token := *p.peeked
+		p.peeked = nil
+		return token, nil
+	}
+
+	return p.t.next()
+}
+
+func (p *sqlDumpParser) peek() (sqlToken, error) {
+	if p.peeked == nil {
+		token, err := p.t.next()
+		if err != nil {
+			return sqlToken{}, err
+		}
+		p.peeked = &token
+	}
+
+	return *p.peeked, nil
+}
+
+func (p *sqlDumpParser) expect(kind sqlTokenKind, text string) error {
+	token, err := p.next()
+	if err != nil {
+		return err
+	}
+
+	if !token.is(kind, text) {
+		return fmt.Errorf("seafile: line %d: expected %q, found %q", token.line, text, token.text)
+	}
+
+	return nil
+}
+
+// skipStatement skips everything up to and including the semicolon at the end of the current statement.
+func (p *sqlDumpParser) skipStatement() error {
+	for {
+		token, err := p.next()
+		if err != nil {
+			return err
+		}
+
+		if token.kind == sqlTokenEOF || token.is(sqlTokenPunct, ";") {
+			return nil
+		}
+	}
+}
+
+// readTableName reads a table name, which may be qualified with the name of its database.
+func (p *sqlDumpParser) readTableName() (string, error) {
+	token, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	if !token.isName() {
+		return "", fmt.Errorf("seafile: line %d: expected a table name, found %q", token.line, token.text)
+	}
+
+	name := token.text
+	for {
+		next, err := p.peek()
+		if err != nil {
+			return "", err
+		}
+		if !next.is(sqlTokenPunct, ".") {
+			return name, nil
+		}
+
+		// the name so far was of the database
+		p.next()
+		token, err = p.next()
+		if err != nil {
+			return "", err
+		}
+		if !token.isName() {
+			return "", fmt.Errorf("seafile: line %d: expected a table name, found %q", token.line, token.text)
+		}
+		name = token.text
+	}
+}
+
+// readNameList reads a parenthesized list of column names.
+func (p *sqlDumpParser) readNameList() ([]string, error) {
+	err := p.expect(sqlTokenPunct, "(")
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for {
+		token, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if !token.isName() {
+			return nil, fmt.Errorf("seafile: line %d: expected a column name, found %q", token.line, token.text)
+		}
+		names = append(names, token.text)
+
+		token, err = p.next()
+		if err != nil {
+			return nil, err
+		}
+		if token.is(sqlTokenPunct, ")") {
+			return names, nil
+		}
+		if !token.is(sqlTokenPunct, ",") {
+			return nil, fmt.Errorf("seafile: line %d: expected \",\" or \")\", found %q", token.line, token.text)
+		}
+	}
+}
+
+// sqlTable is a table defined by a CREATE TABLE statement.
+type sqlTable struct {
+	name    string
+	columns []string
+
+	// rowidColumn is the column declared as INTEGER PRIMARY KEY, which SQLite stores as the row's ID rather than in
+	// the row itself.
+	rowidColumn string
+}
+
+// isRowidAlias returns whether the tokens of a column definition declare it as INTEGER PRIMARY KEY.
+func isRowidAlias(definition []sqlToken) bool {
+	return len(definition) >= 4 &&
+		definition[1].is(sqlTokenWord, "INTEGER") &&
+		definition[2].is(sqlTokenWord, "PRIMARY") &&
+		definition[3].is(sqlTokenWord, "KEY")
+}
+
+// readCreateTable reads the name and columns of a CREATE TABLE statement, after the TABLE keyword, up to the end of
+// the column definitions.
+func (p *sqlDumpParser) readCreateTable() (*sqlTable, error) {
+	token, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	if token.is(sqlTokenWord, "IF") {
+		// IF NOT EXISTS
+		for i := 0; i < 3; i++ {
+			p.next()
+		}
+	}
+
+	table := sqlTable{}
+	table.name, err = p.readTableName()
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.expect(sqlTokenPunct, "(")
+	if err != nil {
+		return nil, err
+	}
+
+	// the tokens at the top level of the current column definition, or nil if it is a key or constraint
+	var definition []sqlToken
+	endItem := func() {
+		if isRowidAlias(definition) {
+			table.rowidColumn = definition[0].text
+		}
+		definition = nil
+	}
+
+	depth := 0
+	startOfItem := true
+	for depth >= 0 {
+		token, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case token.kind == sqlTokenEOF:
+			return nil, fmt.Errorf("seafile: line %d: unterminated CREATE TABLE statement", token.line)
+		case token.is(sqlTokenPunct, "("):
+			depth++
+		case token.is(sqlTokenPunct, ")"):
+			depth--
+		case token.is(sqlTokenPunct, ",") && depth == 0:
+			endItem()
+			startOfItem = true
+			continue
+		case startOfItem && depth == 0:
+			isColumn := token.kind == sqlTokenIdent || token.kind == sqlTokenString
+			if token.kind == sqlTokenWord {
+				switch strings.ToUpper(token.text) {
+				case "PRIMARY", "KEY", "INDEX", "UNIQUE", "FULLTEXT", "SPATIAL", "CONSTRAINT", "FOREIGN", "CHECK":
+					// not a column
+				default:
+					isColumn = true
+				}
+			}
+
+			if isColumn {
+				table.columns = append(table.columns, token.text)
+				definition = []sqlToken{token}
+			}
+		case depth == 0 && definition != nil:
+			definition = append(definition, token)
+		}
+		startOfItem = false
+	}
+	endItem()
+
+	return &table, nil
+}
+
+// parseCreateTable reads the column names of a CREATE TABLE statement, after the TABLE keyword.
+func (p *sqlDumpParser) parseCreateTable() error {
+	table, err := p.readCreateTable()
+	if err != nil {
+		return err
+	}
+
+	if name, wanted := p.wantedTable(table.name); wanted {
+		p.columns[name] = table.columns
+	}
+
+	return p.skipStatement()
+}
+
+// parseCreateTableSQL reads a single CREATE TABLE statement, such as one stored in an SQLite database.
+func parseCreateTableSQL(sql string) (*sqlTable, error) {
+	p := newSQLDumpParser(strings.NewReader(sql), nil)
+
+	err := p.expect(sqlTokenWord, "CREATE")
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		token, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if token.is(sqlTokenWord, "TABLE") {
+			break
+		}
+		if !token.is(sqlTokenWord, "TEMP") && !token.is(sqlTokenWord, "TEMPORARY") {
+			return nil, fmt.Errorf("seafile: expected CREATE TABLE, found %q", token.text)
+		}
+	}
+
+	return p.readCreateTable()
+}
+
+// readValue reads a single value in a row of an INSERT statement.
+func (p *sqlDumpParser) readValue() (sqlValue, error) {
+	parts := []string{}
+	isString := false
+	for {
+		token, err := p.peek()
+		if err != nil {
+			return sqlValue{}, err
+		}
+
+		if token.kind == sqlTokenEOF {
+			return sqlValue{}, fmt.Errorf("seafile: line %d: unterminated INSERT statement", token.line)
+		}
+		if token.is(sqlTokenPunct, ",") || token.is(sqlTokenPunct, ")") {
+			break
+		}
+		p.next()
+
+		if token.kind == sqlTokenWord && strings.HasPrefix(token.text, "_") {
+			// a character set introducer, such as _binary 'abc'
+			next, err := p.peek()
+			if err != nil {
+				return sqlValue{}, err
+			}
+			if next.kind == sqlTokenString {
+				continue
+			}
+		}
+
+		if token.kind == sqlTokenString {
+			isString = true
+		}
+		parts = append(parts, token.text)
+	}
+
+	if len(parts) == 0 {
+		return sqlValue{}, fmt.Errorf("seafile: line %d: missing value", p.t.line)
+	}
+
+	if len(parts) == 1 && !isString && strings.EqualFold(parts[0], "NULL") {
+		return sqlValue{null: true}, nil
+	}
+
+	return sqlValue{text: strings.Join(parts, "")}, nil
+}
+
+// parseInsert reads the rows of an INSERT statement, after the INSERT keyword, and passes each to fn.
+func (p *sqlDumpParser) parseInsert(fn func(table string, row sqlRow) error) error {
+	var token sqlToken
+	var err error
+	for {
+		token, err = p.next()
+		if err != nil {
+			return err
+		}
+
+		// skip modifiers such as IGNORE
+		if token.is(sqlTokenWord, "INTO") {
+			break
+		}
+		if token.kind != sqlTokenWord {
+			return fmt.Errorf("seafile: line %d: expected INTO, found %q", token.line, token.text)
+		}
+	}
+
+	table, err := p.readTableName()
+	if err != nil {
+		return err
+	}
+
+	table, wanted := p.wantedTable(table)
+	if !wanted {
+		return p.skipStatement()
+	}
+	columns := p.columns[table]
+
+	token, err = p.peek()
+	if err != nil {
+		return err
+	}
+	if token.is(sqlTokenPunct, "(") {
+		// written by mysqldump --complete-insert
+		columns, err = p.readNameList()
+		if err != nil {
+			return err
+		}
+	}
+
+	token, err = p.next()
+	if err != nil {
+		return err
+	}
+	if !token.is(sqlTokenWord, "VALUES") && !token.is(sqlTokenWord, "VALUE") {
+		// such as INSERT ... SELECT, which mysqldump doesn't write
+		return p.skipStatement()
+	}
+
+	for {
+		err = p.expect(sqlTokenPunct, "(")
+		if err != nil {
+			return err
+		}
+
+		row := sqlRow{}
+		for i := 0; ; i++ {
+			value, err := p.readValue()
+			if err != nil {
+				return err
+			}
+
+			if i >= len(columns) {
+				return fmt.Errorf("seafile: line %d: too many values for table %s", p.t.line, table)
+			}
+			row[columns[i]] = value
+
+			token, err = p.next()
+			if err != nil {
+				return err
+			}
+			if token.is(sqlTokenPunct, ")") {
+				break
+			}
+		}
+
+		err = fn(table, row)
+		if err != nil {
+			return err
+		}
+
+		token, err = p.next()
+		if err != nil {
+			return err
+		}
+		if token.kind == sqlTokenEOF || token.is(sqlTokenPunct, ";") {
+			return nil
+		}
+		if !token.is(sqlTokenPunct, ",") {
+			// such as ON DUPLICATE KEY UPDATE
+			return p.skipStatement()
+		}
+	}
+}
+
+// parseSet reads a SET statement, after the SET keyword, looking for settings that change how the rest of the file is
+// read.
+func (p *sqlDumpParser) parseSet() error {
+	name, err := p.next()
+	if err != nil {
+		return err
+	}
+
+	if name.is(sqlTokenWord, "standard_conforming_strings") {
+		// = or TO
+		_, err = p.next()
+		if err != nil {
+			return err
+		}
+
+		value, err := p.next()
+		if err != nil {
+			return err
+		}
+
+		p.t.standardStrings = strings.EqualFold(value.text, "on")
+	}
+
+	return p.skipStatement()
+}
+
+// unescapeCopyField decodes a single column written in PostgreSQL's COPY text format.
+func unescapeCopyField(field string) sqlValue {
+	if field == "\\N" {
+		return sqlValue{null: true}
+	}
+	if !strings.Contains(field, "\\") {
+		return sqlValue{text: field}
+	}
+
+	value := strings.Builder{}
+	for i := 0; i < len(field); i++ {
+		c := field[i]
+		if c != '\\' || i+1 == len(field) {
+			value.WriteByte(c)
+			continue
+		}
+
+		i++
+		c = field[i]
+		switch {
+		case c == 'b':
+			value.WriteByte('\b')
+		case c == 'f':
+			value.WriteByte('\f')
+		case c == 'n':
+			value.WriteByte('\n')
+		case c == 'r':
+			value.WriteByte('\r')
+		case c == 't':
+			value.WriteByte('\t')
+		case c == 'v':
+			value.WriteByte('\v')
+		case c == 'x' && i+1 < len(field) && isHexDigit(field[i+1]):
+			// one or two hex digits
+			end := i + 2
+			if end < len(field) && isHexDigit(field[end]) {
+				end++
+			}
+			n, _ := strconv.ParseUint(field[i+1:end], 16, 8)
+			value.WriteByte(byte(n))
+			i = end - 1
+		case c >= '0' && c <= '7':
+			// one to three octal digits
+			end := i + 1
+			for end < len(field) && end < i+3 && field[end] >= '0' && field[end] <= '7' {
+				end++
+			}
+			n, _ := strconv.ParseUint(field[i:end], 8, 16)
+			value.WriteByte(byte(n))
+			i = end - 1
+		default:
+			value.WriteByte(c)
+		}
+	}
+
+	return sqlValue{text: value.String()}
+}
+
+func isHexDigit(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
+
+// parseCopy reads the rows of a COPY ... FROM stdin statement written by pg_dump, after the COPY keyword, and passes
+// each to fn. The rows follow the statement, one per line, up to a line with only "\.".
+func (p *sqlDumpParser) parseCopy(fn func(table string, row sqlRow) error) error {
+	token, err := p.peek()
+	if err != nil {
+		return err
+	}
+	if token.is(sqlTokenWord, "ONLY") {
+		p.next()
+	}
+
+	table, err := p.readTableName()
+	if err != nil {
+		return err
+	}
+
+	table, wanted := p.wantedTable(table)
+	columns := p.columns[table]
+
+	token, err = p.peek()
+	if err != nil {
+		return err
+	}
+	if token.is(sqlTokenPunct, "(") {
+		columns, err = p.readNameList()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = p.expect(sqlTokenWord, "FROM")
+	if err != nil {
+		return err
+	}
+
+	token, err = p.next()
+	if err != nil {
+		return err
+	}
+	if !token.is(sqlTokenWord, "stdin") {
+		// the data is somewhere else, so there's nothing to read
+		return p.skipStatement()
+	}
+
+	// skip any options, without reading past the end of the line that the data starts after
+	for {
+		token, err = p.next()
+		if err != nil {
+			return err
+		}
+		if token.kind == sqlTokenEOF {
+			return fmt.Errorf("seafile: line %d: unterminated COPY statement", token.line)
+		}
+		if token.is(sqlTokenPunct, ";") {
+			break
+		}
+	}
+
+	_, err = p.t.readLine()
+	if err != nil {
+		return fmt.Errorf("seafile: line %d: missing data for COPY statement", p.t.line)
+	}
+
+	for {
+		line, err := p.t.readLine()
+		if err == io.EOF {
+			return fmt.Errorf("seafile: line %d: unterminated COPY data", p.t.line)
+		} else if err != nil {
+			return err
+		}
+
+		if line == "\\." {
+			return nil
+		}
+		if !wanted {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) > len(columns) {
+			return fmt.Errorf("seafile: line %d: too many values for table %s", p.t.line-1, table)
+		}
+
+		row := sqlRow{}
+		for i, field := range fields {
+			row[columns[i]] = unescapeCopyField(field)
+		}
+
+		err = fn(table, row)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func newSQLDumpParser(r io.Reader, columns map[string][]string) *sqlDumpParser {
+	p := sqlDumpParser{
+		t: sqlTokenizer{
+			r:    bufio.NewReaderSize(r, 64*1024),
+			line: 1,
+		},
+		columns: map[string][]string{},
+	}
+	for table, tableColumns := range columns {
+		p.columns[table] = tableColumns
+	}
+
+	return &p
+}
+
+// parseSQLDump reads the output of mysqldump, or the plain format output of pg_dump, and calls fn for every row
+// inserted into one of the given tables. The columns of each table are taken from its CREATE TABLE statement or the
+// INSERT or COPY statement itself, or otherwise from the given columns, for dumps made without table definitions.
+func parseSQLDump(r io.Reader, columns map[string][]string, fn func(table string, row sqlRow) error) error {
+	p := newSQLDumpParser(r, columns)
+
+	for {
+		token, err := p.next()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case token.kind == sqlTokenEOF:
+			return nil
+
+		case token.is(sqlTokenWord, "CREATE"):
+			next, err := p.next()
+			if err != nil {
+				return err
+			}
+			if next.is(sqlTokenWord, "TABLE") {
+				err = p.parseCreateTable()
+			} else {
+				err = p.skipStatement()
+			}
+			if err != nil {
+				return err
+			}
+
+		case token.is(sqlTokenWord, "INSERT") || token.is(sqlTokenWord, "REPLACE"):
+			err = p.parseInsert(fn)
+			if err != nil {
+				return err
+			}
+
+		case token.is(sqlTokenWord, "COPY"):
+			err = p.parseCopy(fn)
+			if err != nil {
+				return err
+			}
+
+		case token.is(sqlTokenWord, "SET"):
+			err = p.parseSet()
+			if err != nil {
+				return err
+			}
+
+		case token.is(sqlTokenPunct, ";"):
+			// an empty statement, like the one after /*!40000 ALTER TABLE ... */;
+
+		default:
+			err = p.skipStatement()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
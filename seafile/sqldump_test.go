@@ -0,0 +1,268 @@
+package seafile
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// describeRows parses the given dump and describes each row it finds in one of the given tables, like
+// "Repo(repo_id='a' name=NULL)", with columns in alphabetical order.
+func describeRows(dump string, columns map[string][]string) ([]string, error) {
+	rows := []string{}
+	err := parseSQLDump(strings.NewReader(dump), columns, func(table string, row sqlRow) error {
+		names := []string{}
+		for name := range row {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		values := []string{}
+		for _, name := range names {
+			if row[name].null {
+				values = append(values, name+"=NULL")
+			} else {
+				values = append(values, fmt.Sprintf("%s=%q", name, row[name].text))
+			}
+		}
+
+		rows = append(rows, table+"("+strings.Join(values, " ")+")")
+		return nil
+	})
+	return rows, err
+}
+
+var testDumpColumns = map[string][]string{
+	"Repo":  {"repo_id", "name"},
+	"Other": {"a", "b"},
+}
+
+func TestParseMySQLDump(t *testing.T) {
+	tests := []struct {
+		name string
+		dump string
+		want []string
+	}{
+		{
+			name: "escaped quotes",
+			dump: `INSERT INTO Repo VALUES ('a','it''s'),('b','it\'s'),('c',"say \"hi\""),('d',"it""s");`,
+			want: []string{
+				`Repo(name="it's" repo_id="a")`,
+				`Repo(name="it's" repo_id="b")`,
+				`Repo(name="say \"hi\"" repo_id="c")`,
+				`Repo(name="it\"s" repo_id="d")`,
+			},
+		},
+		{
+			name: "backslash escapes",
+			dump: `INSERT INTO Repo VALUES ('a','nul\0 newline\n backslash\\ tab\t cr\r z\Z'),('b','like\%\_ other\q');`,
+			want: []string{
+				`Repo(name="nul\x00 newline\n backslash\\ tab\t cr\r z\x1a" repo_id="a")`,
+				// MySQL keeps the backslash before % and _, which are only special in LIKE patterns, and drops it before anything else
+				`Repo(name="like\\%\\_ otherq" repo_id="b")`,
+			},
+		},
+		{
+			name: "NULL",
+			dump: `INSERT INTO Repo VALUES ('a',NULL),('b','NULL'),('c',null),('d','');`,
+			want: []string{
+				`Repo(name=NULL repo_id="a")`,
+				`Repo(name="NULL" repo_id="b")`,
+				`Repo(name=NULL repo_id="c")`,
+				`Repo(name="" repo_id="d")`,
+			},
+		},
+		{
+			name: "multi-row INSERT across lines",
+			dump: "INSERT INTO `Repo` VALUES ('a','one'),\n('b','two'),\n  ('c','three');\nINSERT INTO `Repo` VALUES ('d','four');\n",
+			want: []string{
+				`Repo(name="one" repo_id="a")`,
+				`Repo(name="two" repo_id="b")`,
+				`Repo(name="three" repo_id="c")`,
+				`Repo(name="four" repo_id="d")`,
+			},
+		},
+		{
+			name: "semicolons inside strings",
+			dump: "INSERT INTO Repo VALUES ('a','x;y'),('b',';');\nDROP TABLE IF EXISTS `x;y`;\nINSERT INTO Repo VALUES ('c','\\';');",
+			want: []string{
+				`Repo(name="x;y" repo_id="a")`,
+				`Repo(name=";" repo_id="b")`,
+				`Repo(name="';" repo_id="c")`,
+			},
+		},
+		{
+			name: "comments",
+			dump: "-- MySQL dump 10.13\n" +
+				"/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;\n" +
+				"/*!40000 ALTER TABLE `Repo` DISABLE KEYS */;\n" +
+				"/* a comment with 'quotes' and ; in it */\n" +
+				"# another comment; INSERT INTO Repo VALUES ('x','y');\n" +
+				"INSERT INTO Repo /* inline */ VALUES ('a','--not a comment'),('b','/*nor this*/');\n" +
+				"/*!40000 ALTER TABLE `Repo` ENABLE KEYS */;\n",
+			want: []string{
+				`Repo(name="--not a comment" repo_id="a")`,
+				`Repo(name="/*nor this*/" repo_id="b")`,
+			},
+		},
+		{
+			name: "column list and other tables",
+			dump: "INSERT INTO `Unknown` VALUES ('skipped');\n" +
+				"INSERT INTO `seafile_db`.`Repo` (`name`, `repo_id`) VALUES ('swapped','a');\n" +
+				"INSERT IGNORE INTO repo VALUES (_binary 'b',_utf8mb4'name');\n" +
+				"INSERT INTO Other VALUES (1,-2.5);\n",
+			want: []string{
+				`Repo(name="swapped" repo_id="a")`,
+				`Repo(name="name" repo_id="b")`,
+				`Other(a="1" b="-2.5")`,
+			},
+		},
+		{
+			name: "columns from CREATE TABLE",
+			dump: "CREATE TABLE `Repo` (\n" +
+				"  `id` bigint NOT NULL AUTO_INCREMENT,\n" +
+				"  `repo_id` char(37) DEFAULT NULL,\n" +
+				"  `name` varchar(255) DEFAULT 'a, b',\n" +
+				"  PRIMARY KEY (`id`),\n" +
+				"  UNIQUE KEY `repo_id` (`repo_id`)\n" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8;\n" +
+				"INSERT INTO `Repo` VALUES (1,'a','one');\n",
+			want: []string{
+				`Repo(id="1" name="one" repo_id="a")`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := describeRows(test.dump, testDumpColumns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestParseMySQLDumpErrors(t *testing.T) {
+	for _, dump := range []string{
+		`INSERT INTO Repo VALUES ('a','unterminated);`,
+		`INSERT INTO Repo VALUES ('a','b','too many');`,
+		`INSERT INTO Repo VALUES ('a',`,
+		`INSERT INTO Repo VALUES ('a',);`,
+	} {
+		_, err := describeRows(dump, testDumpColumns)
+		if err == nil {
+			t.Errorf("%s: no error", dump)
+		}
+	}
+}
+
+func TestParsePgDumpCopy(t *testing.T) {
+	tests := []struct {
+		name string
+		dump string
+		want []string
+	}{
+		{
+			name: "NULL",
+			dump: "COPY public.\"Repo\" (repo_id, name) FROM stdin;\na\t\\N\nb\tN\nc\t\n\\.\n",
+			want: []string{
+				`Repo(name=NULL repo_id="a")`,
+				`Repo(name="N" repo_id="b")`,
+				`Repo(name="" repo_id="c")`,
+			},
+		},
+		{
+			name: "escapes",
+			dump: "COPY public.\"Repo\" (repo_id, name) FROM stdin;\n" +
+				"a\ttab\\there\n" +
+				"b\tbackslash\\\\ and \\\\N\n" +
+				"c\tnewline\\n cr\\r bs\\b ff\\f vt\\v\n" +
+				"d\toctal \\101\\0 hex \\x41\\x4a2\n" +
+				"e\tother \\q\n" +
+				"\\.\n",
+			want: []string{
+				`Repo(name="tab\there" repo_id="a")`,
+				`Repo(name="backslash\\ and \\N" repo_id="b")`,
+				`Repo(name="newline\n cr\r bs\b ff\f vt\v" repo_id="c")`,
+				`Repo(name="octal A\x00 hex AJ2" repo_id="d")`,
+				`Repo(name="other q" repo_id="e")`,
+			},
+		},
+		{
+			// the terminator is only a line with nothing else on it
+			name: "terminator",
+			dump: "COPY \"Repo\" (repo_id, name) FROM stdin;\n" +
+				"a\t\\.\n" +
+				"\\.x\tb\n" +
+				"\\.\n" +
+				"COPY \"Repo\" (repo_id, name) FROM stdin;\n" +
+				"c\tafter\n" +
+				"\\.\n",
+			want: []string{
+				`Repo(name="." repo_id="a")`,
+				`Repo(name="b" repo_id=".x")`,
+				`Repo(name="after" repo_id="c")`,
+			},
+		},
+		{
+			name: "column list order",
+			dump: "COPY public.\"Repo\" (name, repo_id) FROM stdin;\nswapped\ta\n\\.\n" +
+				"COPY public.\"Other\" (b, a) FROM stdin;\nsecond\tfirst\n\\.\n",
+			want: []string{
+				`Repo(name="swapped" repo_id="a")`,
+				`Other(a="first" b="second")`,
+			},
+		},
+		{
+			name: "unwanted tables and settings",
+			dump: "--\n-- PostgreSQL database dump\n--\n\n" +
+				"SET standard_conforming_strings = on;\n" +
+				"SELECT pg_catalog.set_config('search_path', '', false);\n" +
+				"COPY public.\"Unknown\" (x) FROM stdin;\n\\.x\tnot a terminator\n\\.\n" +
+				"COPY ONLY public.repo (repo_id, name) FROM stdin WITH (FORMAT text);\na\tlower case\n\\.\n",
+			want: []string{
+				`Repo(name="lower case" repo_id="a")`,
+			},
+		},
+		{
+			name: "INSERT with standard strings",
+			dump: "SET standard_conforming_strings = on;\n" +
+				"INSERT INTO public.\"Repo\" (repo_id, name) VALUES ('a', 'back\\slash'), ('b', E'escaped\\n');\n",
+			want: []string{
+				`Repo(name="back\\slash" repo_id="a")`,
+				`Repo(name="escaped\n" repo_id="b")`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := describeRows(test.dump, testDumpColumns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestParsePgDumpCopyErrors(t *testing.T) {
+	for _, dump := range []string{
+		"COPY \"Repo\" (repo_id, name) FROM stdin;\na\tb\n",
+		"COPY \"Repo\" (repo_id, name) FROM stdin;\na\tb\tc\n\\.\n",
+		"COPY \"Repo\" (repo_id, name) FROM stdin",
+	} {
+		_, err := describeRows(dump, testDumpColumns)
+		if err == nil {
+			t.Errorf("%q: no error", dump)
+		}
+	}
+}
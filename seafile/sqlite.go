@@ -0,0 +1,536 @@
+package seafile
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrNotSQLite is returned by ParseSQLiteFile when the file is not an SQLite database.
+var ErrNotSQLite = errors.New("seafile: not an SQLite database")
+
+const sqliteMagic = "SQLite format 3\x00"
+
+const (
+	sqlitePageInteriorTable = 0x05
+	sqlitePageLeafTable     = 0x0d
+)
+
+const (
+	sqliteWALMagicLittleEndian = 0x377f0682
+	sqliteWALMagicBigEndian    = 0x377f0683
+)
+
+// sqliteDB reads the tables of an SQLite database. Only what is needed to read every row of a table is supported.
+type sqliteDB struct {
+	f fs.File
+
+	pageSize   int
+	usableSize int
+
+	// walFile and walPages give the latest committed version of each page in the write-ahead log, if there is one
+	walFile  fs.File
+	walPages map[uint32]int64
+}
+
+// openRandomAccess opens the file with the given name, reading it into memory if it supports neither io.ReaderAt nor
+// io.Seeker, so that it can be read at any offset.
+func openRandomAccess(fsys fs.FS, name string) (fs.File, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := f.(io.ReaderAt); ok {
+		return f, nil
+	}
+	if _, ok := f.(io.Seeker); ok {
+		return f, nil
+	}
+
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return newMemoryFile(name, data), nil
+}
+
+func openSQLite(fsys fs.FS, name string) (*sqliteDB, error) {
+	f, err := openRandomAccess(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	db := sqliteDB{
+		f: f,
+	}
+
+	header := make([]byte, 100)
+	_, err = readAtOffset(f, header, 0)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		f.Close()
+		return nil, ErrNotSQLite
+	} else if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if string(header[:16]) != sqliteMagic {
+		f.Close()
+		return nil, ErrNotSQLite
+	}
+
+	db.pageSize = int(binary.BigEndian.Uint16(header[16:18]))
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	db.usableSize = db.pageSize - int(header[20])
+
+	if db.pageSize < 512 || db.usableSize < 480 {
+		f.Close()
+		return nil, fmt.Errorf("seafile: %s: invalid SQLite page size %d", name, db.pageSize)
+	}
+
+	encoding := binary.BigEndian.Uint32(header[56:60])
+	if encoding != 0 && encoding != 1 {
+		f.Close()
+		return nil, fmt.Errorf("seafile: %s: only UTF-8 SQLite databases are supported", name)
+	}
+
+	err = db.readWAL(fsys, name+"-wal")
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &db, nil
+}
+
+func (db *sqliteDB) Close() error {
+	if db.walFile != nil {
+		db.walFile.Close()
+	}
+
+	return db.f.Close()
+}
+
+// walChecksum continues the running checksum of the write-ahead log over the given data.
+func walChecksum(order binary.ByteOrder, data []byte, s0 uint32, s1 uint32) (uint32, uint32) {
+	for i := 0; i+8 <= len(data); i += 8 {
+		s0 += order.Uint32(data[i:]) + s1
+		s1 += order.Uint32(data[i+4:]) + s0
+	}
+
+	return s0, s1
+}
+
+// readWAL finds the pages in the database's write-ahead log, if there is one, that have been committed but not yet
+// copied into the database itself.
+func (db *sqliteDB) readWAL(fsys fs.FS, name string) error {
+	f, err := openRandomAccess(fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	header := make([]byte, 32)
+	_, err = readAtOffset(f, header, 0)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		// empty, or never written
+		f.Close()
+		return nil
+	} else if err != nil {
+		f.Close()
+		return err
+	}
+
+	var order binary.ByteOrder
+	switch binary.BigEndian.Uint32(header[0:4]) {
+	case sqliteWALMagicLittleEndian:
+		order = binary.LittleEndian
+	case sqliteWALMagicBigEndian:
+		order = binary.BigEndian
+	default:
+		f.Close()
+		return nil
+	}
+
+	if int(binary.BigEndian.Uint32(header[8:12])) != db.pageSize {
+		f.Close()
+		return nil
+	}
+
+	s0, s1 := walChecksum(order, header[:24], 0, 0)
+	if s0 != binary.BigEndian.Uint32(header[24:28]) || s1 != binary.BigEndian.Uint32(header[28:32]) {
+		f.Close()
+		return nil
+	}
+
+	committed := map[uint32]int64{}
+	pending := map[uint32]int64{}
+
+	frame := make([]byte, 24+db.pageSize)
+	for offset := int64(32); ; offset += int64(len(frame)) {
+		_, err = readAtOffset(f, frame, offset)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		} else if err != nil {
+			f.Close()
+			return err
+		}
+
+		// frames left over from before the log was last reset have different salts
+		if string(frame[8:16]) != string(header[16:24]) {
+			break
+		}
+
+		s0, s1 = walChecksum(order, frame[:8], s0, s1)
+		s0, s1 = walChecksum(order, frame[24:], s0, s1)
+		if s0 != binary.BigEndian.Uint32(frame[16:20]) || s1 != binary.BigEndian.Uint32(frame[20:24]) {
+			break
+		}
+
+		pageNumber := binary.BigEndian.Uint32(frame[0:4])
+		pending[pageNumber] = offset + 24
+
+		if binary.BigEndian.Uint32(frame[4:8]) != 0 {
+			// this frame ends a transaction
+			for pageNumber, pageOffset := range pending {
+				committed[pageNumber] = pageOffset
+			}
+			pending = map[uint32]int64{}
+		}
+	}
+
+	if len(committed) == 0 {
+		f.Close()
+		return nil
+	}
+
+	db.walFile = f
+	db.walPages = committed
+	return nil
+}
+
+func (db *sqliteDB) page(number uint32) ([]byte, error) {
+	if number == 0 {
+		return nil, errors.New("seafile: invalid SQLite page number 0")
+	}
+
+	data := make([]byte, db.pageSize)
+
+	var err error
+	if walOffset, ok := db.walPages[number]; ok {
+		_, err = readAtOffset(db.walFile, data, walOffset)
+	} else {
+		_, err = readAtOffset(db.f, data, int64(number-1)*int64(db.pageSize))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("seafile: reading SQLite page %d: %w", number, err)
+	}
+
+	return data, nil
+}
+
+// readVarint reads an SQLite variable-length integer, returning it and its length.
+func readVarint(data []byte) (uint64, int) {
+	var value uint64
+	for i := 0; i < 9 && i < len(data); i++ {
+		if i == 8 {
+			return (value << 8) | uint64(data[i]), 9
+		}
+
+		value = (value << 7) | uint64(data[i]&0x7f)
+		if data[i]&0x80 == 0 {
+			return value, i + 1
+		}
+	}
+
+	return 0, 0
+}
+
+var errBadSQLitePage = errors.New("seafile: malformed SQLite page")
+
+// readPayload reads the payload of a table leaf cell, whose local part starts at the start of data, following overflow
+// pages if it doesn't all fit.
+func (db *sqliteDB) readPayload(data []byte, size uint64) ([]byte, error) {
+	maxLocal := uint64(db.usableSize - 35)
+	if size <= maxLocal {
+		if uint64(len(data)) < size {
+			return nil, errBadSQLitePage
+		}
+		return data[:size], nil
+	}
+
+	minLocal := uint64((db.usableSize-12)*32/255 - 23)
+	local := minLocal + (size-minLocal)%uint64(db.usableSize-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+
+	if uint64(len(data)) < local+4 {
+		return nil, errBadSQLitePage
+	}
+
+	payload := append([]byte{}, data[:local]...)
+
+	overflow := binary.BigEndian.Uint32(data[local : local+4])
+	for uint64(len(payload)) < size {
+		if overflow == 0 {
+			return nil, errBadSQLitePage
+		}
+
+		page, err := db.page(overflow)
+		if err != nil {
+			return nil, err
+		}
+
+		chunk := page[4:db.usableSize]
+		if remaining := size - uint64(len(payload)); uint64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		payload = append(payload, chunk...)
+
+		overflow = binary.BigEndian.Uint32(page[0:4])
+	}
+
+	return payload, nil
+}
+
+// walkTable calls fn with the rowid and record of every row in the table B-tree with the given root page.
+func (db *sqliteDB) walkTable(rootPage uint32, fn func(rowid int64, record []byte) error) error {
+	visited := map[uint32]bool{}
+
+	var walk func(number uint32) error
+	walk = func(number uint32) error {
+		if visited[number] {
+			return errBadSQLitePage
+		}
+		visited[number] = true
+
+		page, err := db.page(number)
+		if err != nil {
+			return err
+		}
+
+		// the first page starts with the database header
+		headerStart := 0
+		if number == 1 {
+			headerStart = 100
+		}
+
+		pageType := page[headerStart]
+		cellCount := int(binary.BigEndian.Uint16(page[headerStart+3:]))
+
+		headerSize := 8
+		if pageType == sqlitePageInteriorTable {
+			headerSize = 12
+		} else if pageType != sqlitePageLeafTable {
+			return fmt.Errorf("seafile: unsupported SQLite page type %d, the table may be WITHOUT ROWID", pageType)
+		}
+
+		pointers := page[headerStart+headerSize:]
+		if len(pointers) < cellCount*2 {
+			return errBadSQLitePage
+		}
+
+		for i := 0; i < cellCount; i++ {
+			cellStart := int(binary.BigEndian.Uint16(pointers[i*2:]))
+			if cellStart >= len(page) {
+				return errBadSQLitePage
+			}
+			cell := page[cellStart:]
+
+			if pageType == sqlitePageInteriorTable {
+				if len(cell) < 4 {
+					return errBadSQLitePage
+				}
+
+				err = walk(binary.BigEndian.Uint32(cell[0:4]))
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			payloadSize, n := readVarint(cell)
+			if n == 0 {
+				return errBadSQLitePage
+			}
+			cell = cell[n:]
+
+			rowid, n := readVarint(cell)
+			if n == 0 {
+				return errBadSQLitePage
+			}
+			cell = cell[n:]
+
+			payload, err := db.readPayload(cell, payloadSize)
+			if err != nil {
+				return err
+			}
+
+			err = fn(int64(rowid), payload)
+			if err != nil {
+				return err
+			}
+		}
+
+		if pageType == sqlitePageInteriorTable {
+			return walk(binary.BigEndian.Uint32(page[headerStart+8:]))
+		}
+
+		return nil
+	}
+
+	return walk(rootPage)
+}
+
+// parseSQLiteRecord decodes the values of a row, formatting numbers as text.
+func parseSQLiteRecord(record []byte) ([]sqlValue, error) {
+	headerSize, n := readVarint(record)
+	if n == 0 || headerSize > uint64(len(record)) {
+		return nil, errBadSQLitePage
+	}
+
+	values := []sqlValue{}
+	body := record[headerSize:]
+	for header := record[n:headerSize]; len(header) > 0; {
+		serialType, n := readVarint(header)
+		if n == 0 {
+			return nil, errBadSQLitePage
+		}
+		header = header[n:]
+
+		var size uint64
+		switch {
+		case serialType == 0 || serialType == 8 || serialType == 9:
+			size = 0
+		case serialType <= 4:
+			size = serialType
+		case serialType == 5:
+			size = 6
+		case serialType == 6 || serialType == 7:
+			size = 8
+		case serialType >= 12:
+			size = (serialType - 12) / 2
+		default:
+			return nil, errBadSQLitePage
+		}
+
+		if uint64(len(body)) < size {
+			return nil, errBadSQLitePage
+		}
+		data := body[:size]
+		body = body[size:]
+
+		switch {
+		case serialType == 0:
+			values = append(values, sqlValue{null: true})
+		case serialType == 8 || serialType == 9:
+			values = append(values, sqlValue{text: strconv.Itoa(int(serialType - 8))})
+		case serialType <= 6:
+			// a big-endian two's complement integer
+			var value int64
+			if data[0]&0x80 != 0 {
+				value = -1
+			}
+			for _, b := range data {
+				value = (value << 8) | int64(b)
+			}
+			values = append(values, sqlValue{text: strconv.FormatInt(value, 10)})
+		case serialType == 7:
+			value := math.Float64frombits(binary.BigEndian.Uint64(data))
+			values = append(values, sqlValue{text: strconv.FormatFloat(value, 'g', -1, 64)})
+		default:
+			values = append(values, sqlValue{text: string(data)})
+		}
+	}
+
+	return values, nil
+}
+
+// readTables calls fn for every row of the given tables. The columns of each table are read from the database, and the
+// given columns are only used to choose the tables, whose names are matched without regard to case, as SQLite does.
+func (db *sqliteDB) readTables(columns map[string][]string, fn func(table string, row sqlRow) error) error {
+	type tableRoot struct {
+		table    *sqlTable
+		rootPage uint32
+	}
+	tables := map[string]tableRoot{}
+
+	// the schema table describes every other table, and its root is always the first page
+	err := db.walkTable(1, func(rowid int64, record []byte) error {
+		values, err := parseSQLiteRecord(record)
+		if err != nil {
+			return err
+		}
+		if len(values) < 5 || values[0].text != "table" {
+			return nil
+		}
+
+		var name string
+		for wantedName := range columns {
+			if strings.EqualFold(wantedName, values[1].text) {
+				name = wantedName
+			}
+		}
+		if name == "" {
+			return nil
+		}
+
+		table, err := parseCreateTableSQL(values[4].text)
+		if err != nil {
+			return fmt.Errorf("seafile: definition of SQLite table %s: %w", name, err)
+		}
+
+		rootPage, err := strconv.ParseUint(values[3].text, 10, 32)
+		if err != nil {
+			return errBadSQLitePage
+		}
+
+		tables[name] = tableRoot{table, uint32(rootPage)}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for name, t := range tables {
+		err = db.walkTable(t.rootPage, func(rowid int64, record []byte) error {
+			values, err := parseSQLiteRecord(record)
+			if err != nil {
+				return err
+			}
+
+			row := sqlRow{}
+			for i, column := range t.table.columns {
+				if i < len(values) {
+					row[column] = values[i]
+				} else {
+					// added by ALTER TABLE after this row was written
+					row[column] = sqlValue{null: true}
+				}
+			}
+
+			if t.table.rowidColumn != "" {
+				row[t.table.rowidColumn] = sqlValue{text: strconv.FormatInt(rowid, 10)}
+			}
+
+			return fn(name, row)
+		})
+		if err != nil {
+			return fmt.Errorf("seafile: SQLite table %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
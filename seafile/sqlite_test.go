@@ -0,0 +1,197 @@
+package seafile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// The fixtures in testdata are made by testdata/mksqlite.py.
+
+const (
+	sqliteDocsID   = "11111111-1111-4111-8111-111111111111"
+	sqliteSharedID = "22222222-2222-4222-8222-222222222222"
+	sqliteOldID    = "33333333-3333-4333-8333-333333333333"
+	sqliteLongID   = "44444444-4444-4444-8444-444444444444"
+	sqliteLaterID  = "55555555-5555-4555-8555-555555555555"
+)
+
+// sqliteLongName is the name of the repo whose RepoInfo row spills onto overflow pages.
+func sqliteLongName() string {
+	var b strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&b, "%05d ", i)
+	}
+	return b.String()
+}
+
+// sqliteMetadata is what the fixtures hold before the changes that are only in the WAL.
+func sqliteMetadata() *Metadata {
+	return &Metadata{
+		BranchHeads: map[string]string{
+			sqliteDocsID:   strings.Repeat("a", 40),
+			sqliteSharedID: strings.Repeat("b", 40),
+			sqliteLongID:   strings.Repeat("c", 40),
+		},
+		Repos: map[string]RepoMetadata{
+			sqliteDocsID: {
+				Name:  "Docs, 'quoted' ünïcode",
+				Owner: "alice@example.com",
+			},
+			sqliteSharedID: {
+				Name:         "Shared",
+				Owner:        "bob@example.com",
+				Virtual:      true,
+				OriginRepoID: sqliteDocsID,
+				OriginPath:   "/shared",
+			},
+			sqliteOldID: {
+				Name:    "Old",
+				Owner:   "carol@example.com",
+				Garbage: true,
+			},
+			sqliteLongID: {
+				Name:  sqliteLongName(),
+				Owner: "alice@example.com",
+			},
+		},
+		Shares: []Share{
+			{RepoID: sqliteDocsID, From: "alice@example.com", ToUser: "dave@example.com", Permission: "r"},
+			{RepoID: sqliteDocsID, From: "alice@example.com", ToGroupID: "7", Permission: "rw"},
+		},
+	}
+}
+
+// sortShares puts the shares in a fixed order, as the order the tables are read in is not.
+func sortShares(m *Metadata) {
+	sort.Slice(m.Shares, func(i, j int) bool {
+		return m.Shares[i].ToGroupID < m.Shares[j].ToGroupID
+	})
+}
+
+func checkSQLiteMetadata(t *testing.T, got *Metadata, want *Metadata) {
+	t.Helper()
+
+	sortShares(got)
+	sortShares(want)
+
+	for repoID, wantRepo := range want.Repos {
+		if got.Repos[repoID] != wantRepo {
+			t.Errorf("repo %s is %+v, want %+v", repoID, got.Repos[repoID], wantRepo)
+		}
+	}
+	for repoID := range got.Repos {
+		if _, exists := want.Repos[repoID]; !exists {
+			t.Errorf("unexpected repo %s", repoID)
+		}
+	}
+	if !reflect.DeepEqual(got.BranchHeads, want.BranchHeads) {
+		t.Errorf("branch heads are %v, want %v", got.BranchHeads, want.BranchHeads)
+	}
+	if !reflect.DeepEqual(got.Shares, want.Shares) {
+		t.Errorf("shares are %+v, want %+v", got.Shares, want.Shares)
+	}
+}
+
+func TestReadSQLite(t *testing.T) {
+	m, err := ReadSQLite(os.DirFS("testdata"), "seafile.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkSQLiteMetadata(t, m, sqliteMetadata())
+}
+
+func TestReadSQLiteRows(t *testing.T) {
+	db, err := openSQLite(os.DirFS("testdata"), "seafile.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Branch has enough rows to need interior pages, and its id column is stored as the rowid
+	branches := map[string]sqlRow{}
+	err = db.readTables(map[string][]string{"branch": nil}, func(table string, row sqlRow) error {
+		if table != "branch" {
+			t.Errorf("row of unexpected table %s", table)
+		}
+
+		id, _ := row.get("id")
+		branches[id] = row
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(branches) != 403 {
+		t.Fatalf("got %d branches, want 403", len(branches))
+	}
+	for i := 0; i < 400; i++ {
+		row := branches[fmt.Sprint(i+1)]
+		name, _ := row.get("name")
+		commitID, _ := row.get("commit_id")
+		if name != fmt.Sprintf("branch%d", i) || commitID != fmt.Sprintf("%040x", i) {
+			t.Errorf("branch %d is %s at %s", i+1, name, commitID)
+		}
+	}
+	last, _ := branches["403"].get("repo_id")
+	if last != sqliteLongID {
+		t.Errorf("last branch is of repo %s, want %s", last, sqliteLongID)
+	}
+}
+
+func TestReadSQLiteWAL(t *testing.T) {
+	m, err := ReadSQLite(os.DirFS("testdata"), "wal/seafile.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := sqliteMetadata()
+	want.BranchHeads[sqliteDocsID] = strings.Repeat("d", 40)
+	want.BranchHeads[sqliteLaterID] = strings.Repeat("e", 40)
+	docs := want.Repos[sqliteDocsID]
+	docs.Name = "Docs, renamed"
+	want.Repos[sqliteDocsID] = docs
+	old := want.Repos[sqliteOldID]
+	old.Garbage = false
+	want.Repos[sqliteOldID] = old
+	want.Repos[sqliteLaterID] = RepoMetadata{
+		Name:  "Later",
+		Owner: "erin@example.com",
+	}
+
+	checkSQLiteMetadata(t, m, want)
+
+	// without its WAL, the database holds what was last checkpointed
+	data, err := os.ReadFile("testdata/wal/seafile.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = ReadSQLite(fstest.MapFS{"seafile.db": {Data: data}}, "seafile.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkSQLiteMetadata(t, m, sqliteMetadata())
+}
+
+func TestReadSQLiteNotSQLite(t *testing.T) {
+	fsys := fstest.MapFS{
+		"empty.db": {},
+		"dump.sql": {Data: []byte("INSERT INTO Branch VALUES (1,'master','a','b');\n")},
+	}
+
+	for _, name := range []string{"empty.db", "dump.sql"} {
+		_, err := ReadSQLite(fsys, name)
+		if !errors.Is(err, ErrNotSQLite) {
+			t.Errorf("%s: got error %v, want ErrNotSQLite", name, err)
+		}
+	}
+}
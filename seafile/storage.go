@@ -1,25 +1,22 @@
 package seafile
 
 import (
-	"bufio"
 	"errors"
 	"io/fs"
-	"strings"
 )
 
-var ErrGarbageRepo = errors.New("seafile: garbage repo not supported")
-var ErrVirtualRepo = errors.New("seafile: virtual repo not supported")
+var ErrGarbageRepo = errors.New("seafile: repo has been deleted, use OpenGarbageRepo to open it")
+var ErrVirtualRepo = errors.New("seafile: virtual repo has unknown origin")
 
 type Storage struct {
 	rootFsys fs.FS
 	fsys     fs.FS
 
-	haveOptimization bool
-	latestCommits    map[string]string
-	garbageRepos     map[string]bool
-	virtualRepos     map[string]bool
-	repoNames        map[string]string
-	repoOwners       map[string]string
+	fsCache       *fsCache
+	verifyContent bool
+	blockCache    *blockCache
+
+	metadataSource MetadataSource
 }
 
 type RepoInfo struct {
@@ -28,6 +25,13 @@ type RepoInfo struct {
 	Owner   string
 	Virtual bool
 	Garbage bool
+
+	// OriginRepoID and OriginPath are set for virtual repos, and give the folder that the repo shares.
+	OriginRepoID string
+	OriginPath   string
+
+	// Shares lists who the repo has been shared with.
+	Shares []Share
 }
 
 // ListRepoIDs returns a list of all repo IDs.
@@ -46,137 +50,141 @@ func (s *Storage) ListRepoIDs() ([]string, error) {
 	return result, nil
 }
 
-// OpenRepo opens the Repo with the given ID.
+// OpenRepo opens the Repo with the given ID. Repos that have been deleted are refused with ErrGarbageRepo.
+//
+// A virtual repo, which shares a folder of another repo, has its own Commits and branch head, but its fs objects and
+// blocks are read from the storage of the origin repo.
 func (s *Storage) OpenRepo(repoID string) (*Repo, error) {
-	_, garbage := s.garbageRepos[repoID]
-	if garbage {
-		return nil, ErrGarbageRepo
-	}
-	_, virtual := s.virtualRepos[repoID]
-	if virtual {
-		return nil, ErrVirtualRepo
+	m, err := s.Metadata()
+	if err != nil {
+		return nil, err
 	}
 
-	return newRepo(repoID, s.fsys, s), nil
-}
+	if m.Repos[repoID].Garbage {
+		return nil, ErrGarbageRepo
+	}
 
-// GetRepoInfo gets a RepoInfo struct describing the Repo with the given ID.
-func (s *Storage) GetRepoInfo(repoID string) (RepoInfo, error) {
-	return RepoInfo{
-		ID:      repoID,
-		Name:    s.repoNames[repoID],
-		Owner:   s.repoOwners[repoID],
-		Garbage: s.garbageRepos[repoID],
-		Virtual: s.virtualRepos[repoID],
-	}, nil
+	return s.openRepo(m, repoID)
 }
 
-// ParseSQLFile reads the SQL file at the given path and uses that for optimization.
-func (s *Storage) ParseSQLFile(sqlPath string) error {
-	sqlFile, err := s.rootFsys.Open(sqlPath)
+// OpenGarbageRepo opens the Repo with the given ID, even if it has been deleted. Seafile only removes the data of a
+// deleted repo when the garbage collector runs, so until then, it can still be read.
+func (s *Storage) OpenGarbageRepo(repoID string) (*Repo, error) {
+	m, err := s.Metadata()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer sqlFile.Close()
-
-	// TODO: this is a terrible way to parse this and is super brittle :)
-
-	s.latestCommits = map[string]string{}
-	s.garbageRepos = map[string]bool{}
-	s.virtualRepos = map[string]bool{}
-	s.repoNames = map[string]string{}
-	s.repoOwners = map[string]string{}
-
-	scan := bufio.NewScanner(sqlFile)
-	scan.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
-	for scan.Scan() {
-		line := scan.Text()
-
-		if !strings.HasPrefix(line, "INSERT INTO ") {
-			continue
-		}
-
-		// TODO: this code is bad :)
-		if strings.HasPrefix(line, "INSERT INTO `Branch` VALUES ") {
-			valuesString := strings.TrimPrefix(line, "INSERT INTO `Branch` VALUES ")
-			valuesString = strings.TrimSuffix(valuesString, ";")
 
-			valuesStrings := strings.Split(valuesString, "),(")
-
-			for _, valueString := range valuesStrings {
-				valueParts := strings.Split(valueString, ",")
-
-				branch := valueParts[1]
-				if branch != "'master'" {
-					// something weird, ignore it
-					continue
-				}
+	r, err := s.openRepo(m, repoID)
+	if err != nil {
+		return nil, err
+	}
 
-				repoID := strings.Trim(valueParts[2], "'")
-				lastCommitID := strings.Trim(valueParts[3], "')")
+	r.garbage = m.Repos[repoID].Garbage
+	return r, nil
+}
 
-				s.latestCommits[repoID] = lastCommitID
-			}
-		} else if strings.HasPrefix(line, "INSERT INTO `RepoInfo` VALUES ") {
-			valuesString := strings.TrimPrefix(line, "INSERT INTO `RepoInfo` VALUES ")
-			valuesString = strings.TrimSuffix(valuesString, ";")
+func (s *Storage) openRepo(m *Metadata, repoID string) (*Repo, error) {
+	repo := m.Repos[repoID]
+	if repo.Virtual && repo.OriginRepoID == "" {
+		return nil, ErrVirtualRepo
+	}
 
-			valuesStrings := strings.Split(valuesString, "),(")
+	r := newRepo(repoID, s.fsys, s)
+	r.storeID = m.storeID(repoID)
+	return r, nil
+}
 
-			for _, valueString := range valuesStrings {
-				valueParts := strings.Split(valueString, ",")
+// GetRepoInfo gets a RepoInfo struct describing the Repo with the given ID.
+func (s *Storage) GetRepoInfo(repoID string) (RepoInfo, error) {
+	m, err := s.Metadata()
+	if err != nil {
+		return RepoInfo{}, err
+	}
 
-				repoID := strings.Trim(valueParts[1], "'")
-				repoName := strings.Trim(valueParts[2], "')")
+	repo := m.Repos[repoID]
+	shares := []Share{}
+	for _, share := range m.Shares {
+		if share.RepoID == repoID {
+			shares = append(shares, share)
+		}
+	}
 
-				s.repoNames[repoID] = repoName
-			}
-		} else if strings.HasPrefix(line, "INSERT INTO `RepoOwner` VALUES ") {
-			valuesString := strings.TrimPrefix(line, "INSERT INTO `RepoOwner` VALUES ")
-			valuesString = strings.TrimSuffix(valuesString, ";")
+	return RepoInfo{
+		ID:      repoID,
+		Name:    repo.Name,
+		Owner:   repo.Owner,
+		Garbage: repo.Garbage,
+		Virtual: repo.Virtual,
 
-			valuesStrings := strings.Split(valuesString, "),(")
+		OriginRepoID: repo.OriginRepoID,
+		OriginPath:   repo.OriginPath,
 
-			for _, valueString := range valuesStrings {
-				valueParts := strings.Split(valueString, ",")
+		Shares: shares,
+	}, nil
+}
 
-				repoID := strings.Trim(valueParts[1], "'")
-				repoOwner := strings.Trim(valueParts[2], "')")
+// SetMetadataSource sets where the Storage gets the metadata that Seafile keeps in its database, such as the names
+// of repos and their branch heads. Without one, repos have no names or owners, none of them are known to be deleted
+// or virtual, and the latest Commit of each repo is found by reading all of its Commits.
+func (s *Storage) SetMetadataSource(source MetadataSource) {
+	s.metadataSource = source
+}
 
-				s.repoOwners[repoID] = repoOwner
-			}
-		} else if strings.HasPrefix(line, "INSERT INTO `VirtualRepo` VALUES ") {
-			valuesString := strings.TrimPrefix(line, "INSERT INTO `VirtualRepo` VALUES ")
-			valuesString = strings.TrimSuffix(valuesString, ";")
+// noMetadata is used when the Storage has no MetadataSource.
+var noMetadata = newMetadata()
 
-			valuesStrings := strings.Split(valuesString, "),(")
+// Metadata returns the current metadata from the Storage's MetadataSource, or an empty Metadata if it has none. A
+// source that refreshes itself returns a different Metadata after each refresh that changed something.
+func (s *Storage) Metadata() (*Metadata, error) {
+	if s.metadataSource == nil {
+		return noMetadata, nil
+	}
 
-			for _, valueString := range valuesStrings {
-				valueParts := strings.Split(valueString, ",")
+	return s.metadataSource.Metadata()
+}
 
-				repoID := strings.Trim(valueParts[1], "'")
+// WithCurrentMetadata returns a copy of the Storage that always uses the metadata its MetadataSource has now, so that
+// a series of calls all see the same metadata even if the source refreshes in between. The copy shares the Storage's
+// caches, but later changes to the settings of either don't affect the other.
+func (s *Storage) WithCurrentMetadata() (*Storage, error) {
+	pinned := *s
+	if s.metadataSource == nil {
+		return &pinned, nil
+	}
 
-				s.virtualRepos[repoID] = true
-			}
-		} else if strings.HasPrefix(line, "INSERT INTO `GarbageRepos` VALUES ") {
-			valuesString := strings.TrimPrefix(line, "INSERT INTO `GarbageRepos` VALUES ")
-			valuesString = strings.TrimSuffix(valuesString, ";")
+	m, err := s.metadataSource.Metadata()
+	if err != nil {
+		return nil, err
+	}
 
-			valuesStrings := strings.Split(valuesString, "),(")
+	pinned.metadataSource = m
+	return &pinned, nil
+}
 
-			for _, valueString := range valuesStrings {
-				valueParts := strings.Split(valueString, ",")
+// ParseSQLFile reads the SQL file at the given path, which should be a dump of the seafile_db database made by
+// mysqldump or, in its plain format, pg_dump, and uses it as the Storage's MetadataSource.
+func (s *Storage) ParseSQLFile(sqlPath string) error {
+	m, err := ReadSQLDump(s.rootFsys, sqlPath)
+	if err != nil {
+		return err
+	}
 
-				repoID := strings.Trim(valueParts[1], "'")
+	s.SetMetadataSource(m)
+	return nil
+}
 
-				s.garbageRepos[repoID] = true
-			}
-		}
+// ParseSQLiteFile reads the seafile.db SQLite database at the given path, which Seafile uses when it is not set up
+// with MySQL, and uses it as the Storage's MetadataSource. Changes still in the database's write-ahead log are
+// included.
+func (s *Storage) ParseSQLiteFile(sqlitePath string) error {
+	m, err := ReadSQLite(s.rootFsys, sqlitePath)
+	if err != nil {
+		return err
 	}
 
-	s.haveOptimization = true
-
-	return scan.Err()
+	s.SetMetadataSource(m)
+	return nil
 }
 
 // NewStorageWithFS creates a new Storage with the given fs.FS.
@@ -194,5 +202,20 @@ func NewStorageWithFSSubpath(fsys fs.FS, subpath string) *Storage {
 	return &Storage{
 		fsys:     sub,
 		rootFsys: fsys,
+
+		fsCache:    newFSCache(DefaultFSCacheSize),
+		blockCache: newBlockCache(DefaultBlockCacheSize),
 	}
 }
+
+// SetFSCacheSize sets the approximate number of bytes of decoded fs objects that are kept in memory, and shared by
+// every FS opened from the Storage. A size of 0 disables the cache.
+func (s *Storage) SetFSCacheSize(size int64) {
+	s.fsCache = newFSCache(size)
+}
+
+// SetBlockCacheSize sets the number of bytes of blocks that are kept in memory after being checked against their IDs,
+// when content verification is enabled. A size of 0 disables the cache, so every read of a block checks it again.
+func (s *Storage) SetBlockCacheSize(size int64) {
+	s.blockCache = newBlockCache(size)
+}
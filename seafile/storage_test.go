@@ -0,0 +1,192 @@
+package seafile_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thatoddmailbox/seafile-browse/seafile"
+	"github.com/thatoddmailbox/seafile-browse/seafile/seafiletest"
+)
+
+// testTime is when the first commit of test libraries is made, with later commits an hour apart.
+var testTime = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func commitAt(hour int, files map[string]string) seafiletest.Commit {
+	return seafiletest.Commit{
+		Time:        testTime.Add(time.Duration(hour) * time.Hour),
+		Description: "Commit",
+		Creator:     "alice@example.com",
+		Files:       files,
+	}
+}
+
+// openTestStorage builds the given data in memory, and opens it with its metadata.
+func openTestStorage(t *testing.T, d *seafiletest.Data) *seafile.Storage {
+	t.Helper()
+
+	fsys, err := d.MapFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := seafile.NewStorageWithFS(fsys)
+	s.SetMetadataSource(d.Metadata())
+	return s
+}
+
+func TestOpenVirtualRepo(t *testing.T) {
+	d := &seafiletest.Data{
+		Libraries: []seafiletest.Library{
+			{
+				Name:  "Docs",
+				Owner: "alice@example.com",
+				Commits: []seafiletest.Commit{
+					commitAt(0, map[string]string{"shared/a.txt": "one", "other.txt": "other"}),
+					commitAt(1, map[string]string{"shared/a.txt": "two", "shared/sub/b.txt": "b", "other.txt": "other"}),
+				},
+			},
+			{
+				Name:        "Shared",
+				Owner:       "bob@example.com",
+				VirtualOf:   "Docs",
+				VirtualPath: "/shared",
+			},
+		},
+	}
+	s := openTestStorage(t, d)
+	origin := d.Libraries[0]
+	virtual := d.Libraries[1]
+
+	r, err := s.OpenRepo(virtual.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.IsVirtual() {
+		t.Error("IsVirtual() = false, want true")
+	}
+
+	// the virtual repo has its own head, rather than its origin's
+	head, err := r.GetLatestCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.CommitID != virtual.Commits[0].ID {
+		t.Errorf("head is %s, want the virtual repo's own commit %s", head.CommitID, virtual.Commits[0].ID)
+	}
+	if head.CommitID == origin.Commits[1].ID {
+		t.Error("head is the origin's commit")
+	}
+
+	history, err := r.History(seafile.HistoryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 {
+		t.Errorf("history has %d commits, want 1", len(history))
+	}
+
+	// while its files are read from the origin's storage
+	sfs, err := head.GetFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"a.txt": "two", "sub/b.txt": "b"} {
+		data, err := sfs.ReadFile(name)
+		if err != nil {
+			t.Errorf("ReadFile(%q): %v", name, err)
+			continue
+		}
+		if string(data) != want {
+			t.Errorf("ReadFile(%q) = %q, want %q", name, data, want)
+		}
+	}
+	if _, err := sfs.Stat("other.txt"); err == nil {
+		t.Error("other.txt, which is outside the shared folder, is visible")
+	}
+
+	result, err := s.Check(virtual.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Problems) > 0 {
+		t.Errorf("Check found problems: %v", result.Problems)
+	}
+}
+
+func TestOpenVirtualRepoUnknownOrigin(t *testing.T) {
+	d := &seafiletest.Data{
+		Libraries: []seafiletest.Library{
+			{Name: "Docs", Commits: []seafiletest.Commit{commitAt(0, map[string]string{"a.txt": "a"})}},
+		},
+	}
+	s := openTestStorage(t, d)
+
+	m := d.Metadata()
+	m.Repos["virtual"] = seafile.RepoMetadata{Virtual: true}
+	s.SetMetadataSource(m)
+
+	_, err := s.OpenRepo("virtual")
+	if err != seafile.ErrVirtualRepo {
+		t.Errorf("OpenRepo returned %v, want ErrVirtualRepo", err)
+	}
+}
+
+func TestOpenGarbageRepo(t *testing.T) {
+	d := &seafiletest.Data{
+		Libraries: []seafiletest.Library{
+			{Name: "Docs", Commits: []seafiletest.Commit{commitAt(0, map[string]string{"a.txt": "a"})}},
+			{Name: "Old", Garbage: true, Commits: []seafiletest.Commit{commitAt(0, map[string]string{"b.txt": "b"})}},
+		},
+	}
+	s := openTestStorage(t, d)
+	docs := d.Libraries[0]
+	old := d.Libraries[1]
+
+	_, err := s.OpenRepo(old.ID)
+	if err != seafile.ErrGarbageRepo {
+		t.Errorf("OpenRepo of a deleted repo returned %v, want ErrGarbageRepo", err)
+	}
+
+	r, err := s.OpenGarbageRepo(old.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.IsGarbage() {
+		t.Error("IsGarbage() = false for a deleted repo, want true")
+	}
+
+	// its data can still be read
+	head, err := r.GetLatestCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.CommitID != old.Commits[0].ID {
+		t.Errorf("head is %s, want %s", head.CommitID, old.Commits[0].ID)
+	}
+	sfs, err := head.GetFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := sfs.ReadFile("b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "b" {
+		t.Errorf("ReadFile(\"b.txt\") = %q, want \"b\"", data)
+	}
+
+	// a repo that hasn't been deleted opens either way, and isn't garbage
+	for name, open := range map[string]func(string) (*seafile.Repo, error){
+		"OpenRepo":        s.OpenRepo,
+		"OpenGarbageRepo": s.OpenGarbageRepo,
+	} {
+		r, err := open(docs.ID)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if r.IsGarbage() {
+			t.Errorf("%s: IsGarbage() = true for a repo that hasn't been deleted", name)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+#!/usr/bin/env python3
+# Makes the SQLite fixtures used by sqlite_test.go, laid out like the seafile.db that Seafile creates when it isn't set
+# up with MySQL or PostgreSQL. Run it from this directory.
+#
+# seafile.db is a plain database. wal/seafile.db is in WAL mode, and is copied along with its write-ahead log while
+# the connection is still open, so the log holds changes that have not been checkpointed into the database yet.
+
+import os
+import shutil
+import sqlite3
+
+SCHEMA = """
+CREATE TABLE Branch (id INTEGER PRIMARY KEY AUTOINCREMENT, name VARCHAR(10), repo_id CHAR(41), commit_id CHAR(41), UNIQUE(repo_id, name));
+CREATE TABLE RepoOwner (id INTEGER PRIMARY KEY AUTOINCREMENT, repo_id CHAR(37), owner_id TEXT);
+CREATE TABLE RepoInfo (id INTEGER PRIMARY KEY AUTOINCREMENT, repo_id CHAR(36), name VARCHAR(255) NOT NULL, update_time INTEGER, version INTEGER, is_encrypted INTEGER, last_modifier VARCHAR(255), status INTEGER DEFAULT 0);
+CREATE TABLE VirtualRepo (id INTEGER PRIMARY KEY AUTOINCREMENT, repo_id CHAR(36), origin_repo CHAR(36), path TEXT, base_commit CHAR(40));
+CREATE TABLE GarbageRepos (id INTEGER PRIMARY KEY AUTOINCREMENT, repo_id CHAR(36));
+CREATE TABLE SharedRepo (id INTEGER PRIMARY KEY AUTOINCREMENT, repo_id CHAR(37), from_email VARCHAR(255), to_email VARCHAR(255), permission CHAR(15));
+CREATE TABLE RepoGroup (id INTEGER PRIMARY KEY AUTOINCREMENT, repo_id CHAR(37), group_id INTEGER, user_name VARCHAR(255), permission CHAR(15));
+CREATE INDEX RepoOwnerIndex ON RepoOwner (owner_id);
+"""
+
+DOCS = "11111111-1111-4111-8111-111111111111"
+SHARED = "22222222-2222-4222-8222-222222222222"
+OLD = "33333333-3333-4333-8333-333333333333"
+LONG = "44444444-4444-4444-8444-444444444444"
+LATER = "55555555-5555-4555-8555-555555555555"
+
+# long enough to spill onto several overflow pages
+LONG_NAME = "".join("%05d " % i for i in range(2000))
+
+
+def fill(con):
+    con.executescript(SCHEMA)
+    for repo_id, name, owner in [
+        (DOCS, "Docs, 'quoted' ünïcode", "alice@example.com"),
+        (SHARED, "Shared", "bob@example.com"),
+        (OLD, "Old", "carol@example.com"),
+        (LONG, LONG_NAME, "alice@example.com"),
+    ]:
+        con.execute("INSERT INTO RepoInfo (repo_id, name, update_time, version, is_encrypted, last_modifier) VALUES (?, ?, ?, 1, 0, ?)", (repo_id, name, 1622548800, owner))
+        con.execute("INSERT INTO RepoOwner (repo_id, owner_id) VALUES (?, ?)", (repo_id, owner))
+    con.execute("INSERT INTO VirtualRepo (repo_id, origin_repo, path, base_commit) VALUES (?, ?, '/shared', NULL)", (SHARED, DOCS))
+    con.execute("INSERT INTO GarbageRepos (repo_id) VALUES (?)", (OLD,))
+    con.execute("INSERT INTO SharedRepo (repo_id, from_email, to_email, permission) VALUES (?, 'alice@example.com', 'dave@example.com', 'r')", (DOCS,))
+    con.execute("INSERT INTO RepoGroup (repo_id, group_id, user_name, permission) VALUES (?, 7, 'alice@example.com', 'rw')", (DOCS,))
+
+    # enough branches for the table to need interior pages
+    for i in range(400):
+        con.execute("INSERT INTO Branch (name, repo_id, commit_id) VALUES (?, ?, ?)", ("branch%d" % i, "%08d-0000-4000-8000-000000000000" % i, "%040x" % i))
+    for repo_id, commit in [(DOCS, "a" * 40), (SHARED, "b" * 40), (LONG, "c" * 40)]:
+        con.execute("INSERT INTO Branch (name, repo_id, commit_id) VALUES ('master', ?, ?)", (repo_id, commit))
+    con.commit()
+
+
+def make_plain():
+    if os.path.exists("seafile.db"):
+        os.remove("seafile.db")
+    con = sqlite3.connect("seafile.db")
+    fill(con)
+    con.close()
+
+
+def make_wal():
+    os.makedirs("wal", exist_ok=True)
+    tmp = "wal-tmp"
+    shutil.rmtree(tmp, ignore_errors=True)
+    os.makedirs(tmp)
+
+    con = sqlite3.connect(os.path.join(tmp, "seafile.db"))
+    con.execute("PRAGMA journal_mode=WAL")
+    con.execute("PRAGMA wal_autocheckpoint=0")
+    fill(con)
+    con.execute("PRAGMA wal_checkpoint(TRUNCATE)")
+
+    # these only reach the log
+    con.execute("UPDATE RepoInfo SET name = 'Docs, renamed' WHERE repo_id = ?", (DOCS,))
+    con.execute("UPDATE Branch SET commit_id = ? WHERE repo_id = ? AND name = 'master'", ("d" * 40, DOCS))
+    con.execute("DELETE FROM GarbageRepos")
+    con.execute("INSERT INTO RepoInfo (repo_id, name, update_time, version, is_encrypted, last_modifier) VALUES (?, 'Later', 1622552400, 1, 0, 'erin@example.com')", (LATER,))
+    con.execute("INSERT INTO RepoOwner (repo_id, owner_id) VALUES (?, 'erin@example.com')", (LATER,))
+    con.execute("INSERT INTO Branch (name, repo_id, commit_id) VALUES ('master', ?, ?)", (LATER, "e" * 40))
+    con.commit()
+
+    for name in ["seafile.db", "seafile.db-wal"]:
+        shutil.copyfile(os.path.join(tmp, name), os.path.join("wal", name))
+    con.close()
+    shutil.rmtree(tmp)
+
+
+make_plain()
+make_wal()
@@ -0,0 +1,301 @@
+package seafile
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+)
+
+// Usage describes how much space a Repo takes up.
+type Usage struct {
+	RepoID string
+
+	// HeadSize is the total size of the files in the latest Commit.
+	HeadSize int64
+
+	// HistorySize is the total size of every distinct version of every file in the Repo's history, which is what
+	// would be needed to store all of it without deduplication.
+	HistorySize int64
+
+	// PhysicalSize is the total size of the distinct blocks used by the Repo's history, as stored. Blocks is the number
+	// of those blocks.
+	PhysicalSize int64
+	Blocks       int
+
+	// SharedSize is the part of PhysicalSize that is also used by other libraries, which are the virtual repos that
+	// share folders of this one. It is only filled in by Storage.Usage.
+	SharedSize int64
+}
+
+// DedupSavings returns how much less space the Repo's history takes up as stored than it would without
+// deduplication, which is HistorySize less PhysicalSize.
+func (u *Usage) DedupSavings() int64 {
+	return u.HistorySize - u.PhysicalSize
+}
+
+// OwnerUsage is the total Usage of the Repos belonging to a single owner.
+type OwnerUsage struct {
+	Owner string
+	Repos int
+
+	HeadSize     int64
+	HistorySize  int64
+	PhysicalSize int64
+	SharedSize   int64
+}
+
+// DedupSavings returns how much less space the owner's Repos take up as stored than they would without
+// deduplication.
+func (u *OwnerUsage) DedupSavings() int64 {
+	return u.HistorySize - u.PhysicalSize
+}
+
+type usageWalker struct {
+	r *Repo
+
+	dirSizes map[string]int64
+	files    map[string]bool
+	blocks   map[string]bool
+	usage    *Usage
+}
+
+// dirSize returns the total size of the files in the given directory, and records the files and blocks it uses.
+func (w *usageWalker) dirSize(id string) (int64, error) {
+	if id == emptyID {
+		return 0, nil
+	}
+
+	size, seen := w.dirSizes[id]
+	if seen {
+		return size, nil
+	}
+
+	i, err := w.r.readFSObject(id)
+	if errors.Is(err, fs.ErrNotExist) {
+		// missing objects take up no space, fsck is what reports them
+		w.dirSizes[id] = 0
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	for _, dirent := range i.Dirents {
+		if direntIsDir(&dirent) {
+			subSize, err := w.dirSize(dirent.ID)
+			if err != nil {
+				return 0, err
+			}
+
+			size += subSize
+			continue
+		}
+
+		size += dirent.Size
+
+		err = w.addFile(dirent.ID, dirent.Size)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	w.dirSizes[id] = size
+	return size, nil
+}
+
+func (w *usageWalker) addFile(id string, size int64) error {
+	if id == emptyID || w.files[id] {
+		return nil
+	}
+	w.files[id] = true
+	w.usage.HistorySize += size
+
+	i, err := w.r.readFSObject(id)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, blockID := range i.BlockIDs {
+		if w.blocks[blockID] {
+			continue
+		}
+		w.blocks[blockID] = true
+
+		info, err := fs.Stat(w.r.fsys, path.Join("storage", "blocks", w.r.storeID, blockID[:2], blockID[2:]))
+		if errors.Is(err, fs.ErrNotExist) {
+			// nothing stored, so it takes up no space
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		w.usage.PhysicalSize += info.Size()
+		w.usage.Blocks++
+	}
+
+	return nil
+}
+
+func newUsageWalker(r *Repo) *usageWalker {
+	return &usageWalker{
+		r: r,
+
+		dirSizes: map[string]int64{},
+		files:    map[string]bool{},
+		blocks:   map[string]bool{},
+		usage: &Usage{
+			RepoID: r.id,
+		},
+	}
+}
+
+// addHistory records the files and blocks used by every Commit in the history of the given Repo, which must store
+// its data in the same place as the walker's other Repos. It returns the size of the latest Commit.
+func (w *usageWalker) addHistory(r *Repo) (int64, error) {
+	w.r = r
+
+	head, err := r.GetLatestCommit()
+	if err != nil {
+		return 0, err
+	}
+	if head == nil {
+		return 0, nil
+	}
+
+	headSize, err := w.dirSize(head.RootID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = r.walkHistoryFrom(head, HistoryOptions{}, func(c *Commit) error {
+		_, err := w.dirSize(c.RootID)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return headSize, nil
+}
+
+// Usage works out how much space the Repo takes up, looking at every Commit in its history.
+func (r *Repo) Usage() (*Usage, error) {
+	w := newUsageWalker(r)
+
+	var err error
+	w.usage.HeadSize, err = w.addHistory(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.usage, nil
+}
+
+// sharedSize works out the stored size of the blocks of the given Repo that are also used by the history of its
+// virtual repos, counting each block once however many of them use it.
+func (s *Storage) sharedSize(m *Metadata, origin *Repo) (int64, error) {
+	virtualIDs := []string{}
+	for repoID, repo := range m.Repos {
+		if repo.Virtual && repo.OriginRepoID == origin.id {
+			virtualIDs = append(virtualIDs, repoID)
+		}
+	}
+	sort.Strings(virtualIDs)
+
+	// the virtual repos all store their data in the origin, so the walk can share what it has seen between them
+	w := newUsageWalker(origin)
+	for _, repoID := range virtualIDs {
+		r, err := s.OpenGarbageRepo(repoID)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = w.addHistory(r)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return w.usage.PhysicalSize, nil
+}
+
+// Usage works out how much space each of the Repos with the given IDs takes up. Deleted repos are included; virtual
+// repos are skipped, as their data belongs to their origin repo, and the part of it they use is the origin's
+// SharedSize.
+//
+// Each Repo stores its own blocks, so the PhysicalSizes can be added up without counting anything twice.
+func (s *Storage) Usage(repoIDs []string) ([]*Usage, error) {
+	result := []*Usage{}
+
+	m, err := s.Metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, repoID := range repoIDs {
+		if m.Repos[repoID].Virtual {
+			continue
+		}
+
+		r, err := s.OpenGarbageRepo(repoID)
+		if err != nil {
+			return nil, err
+		}
+
+		usage, err := r.Usage()
+		if err != nil {
+			return nil, err
+		}
+
+		usage.SharedSize, err = s.sharedSize(m, r)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, usage)
+	}
+
+	return result, nil
+}
+
+// UsageByOwner adds up the given Usages for each Repo owner, sorted by PhysicalSize, largest first.
+func (s *Storage) UsageByOwner(usages []*Usage) ([]OwnerUsage, error) {
+	byOwner := map[string]*OwnerUsage{}
+	for _, usage := range usages {
+		inf, err := s.GetRepoInfo(usage.RepoID)
+		if err != nil {
+			return nil, err
+		}
+
+		ownerUsage, exists := byOwner[inf.Owner]
+		if !exists {
+			ownerUsage = &OwnerUsage{
+				Owner: inf.Owner,
+			}
+			byOwner[inf.Owner] = ownerUsage
+		}
+
+		ownerUsage.Repos++
+		ownerUsage.HeadSize += usage.HeadSize
+		ownerUsage.HistorySize += usage.HistorySize
+		ownerUsage.PhysicalSize += usage.PhysicalSize
+		ownerUsage.SharedSize += usage.SharedSize
+	}
+
+	result := []OwnerUsage{}
+	for _, ownerUsage := range byOwner {
+		result = append(result, *ownerUsage)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].PhysicalSize == result[j].PhysicalSize {
+			return result[i].Owner < result[j].Owner
+		}
+
+		return result[i].PhysicalSize > result[j].PhysicalSize
+	})
+
+	return result, nil
+}
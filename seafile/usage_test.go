@@ -0,0 +1,84 @@
+package seafile_test
+
+import (
+	"testing"
+
+	"github.com/thatoddmailbox/seafile-browse/seafile"
+	"github.com/thatoddmailbox/seafile-browse/seafile/seafiletest"
+)
+
+func TestUsage(t *testing.T) {
+	d := &seafiletest.Data{
+		BlockSize: 4,
+		Libraries: []seafiletest.Library{
+			{
+				Name:  "Docs",
+				Owner: "alice@example.com",
+				Commits: []seafiletest.Commit{
+					commitAt(0, map[string]string{"shared/a.txt": "aaaa", "b.txt": "bb"}),
+					// a copy takes up no more space when stored, but counts again in the head, and a different file
+					// made of blocks that are already stored only counts in the history
+					commitAt(1, map[string]string{"shared/a.txt": "aaaa", "b.txt": "bbbbbb", "copy.txt": "aaaa", "dup.txt": "aaaabb"}),
+				},
+			},
+			// both share the same folder, whose blocks are only counted once
+			{Name: "Shared", Owner: "bob@example.com", VirtualOf: "Docs", VirtualPath: "/shared"},
+			{Name: "Shared again", Owner: "carol@example.com", VirtualOf: "Docs", VirtualPath: "/shared"},
+			{
+				Name:    "Old",
+				Owner:   "alice@example.com",
+				Garbage: true,
+				Commits: []seafiletest.Commit{commitAt(0, map[string]string{"c.txt": "ccc"})},
+			},
+		},
+	}
+	s := openTestStorage(t, d)
+
+	repoIDs, err := s.ListRepoIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usages, err := s.Usage(repoIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type sizes struct {
+		head, history, physical, shared, savings int64
+		blocks                                   int
+	}
+	want := map[string]sizes{
+		// the blocks are "aaaa", "bb" and "bbbb"
+		d.Libraries[0].ID: {head: 4 + 6 + 4 + 6, history: 4 + 2 + 6 + 6, physical: 4 + 2 + 4, shared: 4, savings: 8, blocks: 3},
+		d.Libraries[3].ID: {head: 3, history: 3, physical: 3, blocks: 1},
+	}
+	if len(usages) != len(want) {
+		t.Fatalf("got usage of %d libraries, want %d, as the virtual one belongs to its origin", len(usages), len(want))
+	}
+	for _, usage := range usages {
+		got := sizes{usage.HeadSize, usage.HistorySize, usage.PhysicalSize, usage.SharedSize, usage.DedupSavings(), usage.Blocks}
+		if got != want[usage.RepoID] {
+			t.Errorf("%s: got %+v, want %+v", usage.RepoID, got, want[usage.RepoID])
+		}
+	}
+
+	owners, err := s.UsageByOwner(usages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOwner := seafile.OwnerUsage{
+		Owner:        "alice@example.com",
+		Repos:        2,
+		HeadSize:     20 + 3,
+		HistorySize:  18 + 3,
+		PhysicalSize: 10 + 3,
+		SharedSize:   4,
+	}
+	if len(owners) != 1 || owners[0] != wantOwner {
+		t.Fatalf("got owner usage %+v, want %+v", owners, wantOwner)
+	}
+	if owners[0].DedupSavings() != 8 {
+		t.Errorf("owner saves %d bytes by deduplication, want 8", owners[0].DedupSavings())
+	}
+}
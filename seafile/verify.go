@@ -0,0 +1,77 @@
+package seafile
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// ErrCorruptObject is wrapped by every CorruptObjectError, so that it can be checked for with errors.Is.
+var ErrCorruptObject = errors.New("seafile: object does not match its ID")
+
+// CorruptObjectError is returned when content verification is enabled and an object's content does not hash to its
+// ID, meaning that it has been damaged.
+type CorruptObjectError struct {
+	// Kind is either "block" or "fs object".
+	Kind   string
+	RepoID string
+	ID     string
+
+	// ActualID is the hash of the content that was read.
+	ActualID string
+}
+
+func (e *CorruptObjectError) Error() string {
+	return fmt.Sprintf("seafile: %s %s in repo %s is corrupt (content hashes to %s)", e.Kind, e.ID, e.RepoID, e.ActualID)
+}
+
+func (e *CorruptObjectError) Unwrap() error {
+	return ErrCorruptObject
+}
+
+// SetVerifyContent sets whether blocks and fs objects are checked against their IDs, which are SHA-1 hashes of their
+// content, as they are read. When enabled, a mismatch returns a *CorruptObjectError rather than the damaged data.
+//
+// Each block is read into memory in full to be checked before any of it is returned, which makes reading slower. The
+// Storage keeps the contents of recently checked blocks in memory, up to the size set with SetBlockCacheSize, so later
+// reads of the same block use the checked copy rather than reading it again. Cached fs objects are only used if they
+// were checked when they were read.
+func (s *Storage) SetVerifyContent(verify bool) {
+	s.verifyContent = verify
+}
+
+// verifyData returns a *CorruptObjectError if data does not hash to the given ID.
+func verifyData(kind string, repoID string, id string, data []byte) error {
+	sum := sha1.Sum(data)
+	actualID := hex.EncodeToString(sum[:])
+	if actualID != id {
+		return &CorruptObjectError{
+			Kind:     kind,
+			RepoID:   repoID,
+			ID:       id,
+			ActualID: actualID,
+		}
+	}
+
+	return nil
+}
+
+// verifyBlock reads the given block in full and checks it against its ID, returning its content if it's intact.
+func verifyBlock(f fs.File, repoID string, blockID string) ([]byte, error) {
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	err = verifyData("block", repoID, blockID, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
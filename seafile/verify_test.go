@@ -0,0 +1,201 @@
+package seafile_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/thatoddmailbox/seafile-browse/seafile"
+	"github.com/thatoddmailbox/seafile-browse/seafile/seafiletest"
+)
+
+// countingFS counts the bytes read from its blocks.
+type countingFS struct {
+	fsys fs.FS
+
+	lock       sync.Mutex
+	blockBytes int
+}
+
+type countingFile struct {
+	fs.File
+	c *countingFS
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	f, err := c.fsys.Open(name)
+	if err != nil || !strings.HasPrefix(name, "storage/blocks/") {
+		return f, err
+	}
+	return &countingFile{f, c}, nil
+}
+
+func (c *countingFS) count(n int) {
+	c.lock.Lock()
+	c.blockBytes += n
+	c.lock.Unlock()
+}
+
+func (f *countingFile) Read(b []byte) (int, error) {
+	n, err := f.File.Read(b)
+	f.c.count(n)
+	return n, err
+}
+
+func (f *countingFile) ReadAt(b []byte, offset int64) (int, error) {
+	n, err := f.File.(io.ReaderAt).ReadAt(b, offset)
+	f.c.count(n)
+	return n, err
+}
+
+func (f *countingFile) Seek(offset int64, whence int) (int64, error) {
+	return f.File.(io.Seeker).Seek(offset, whence)
+}
+
+func openVerifyTestFile(t *testing.T, fsys fs.FS, d *seafiletest.Data, verify bool) (*seafile.Storage, *seafile.FS) {
+	t.Helper()
+
+	s := seafile.NewStorageWithFS(fsys)
+	s.SetMetadataSource(d.Metadata())
+	s.SetVerifyContent(verify)
+
+	r, err := s.OpenRepo(d.Libraries[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := r.GetLatestCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sfs, err := c.GetFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, sfs
+}
+
+func TestVerifiedBlocksAreCached(t *testing.T) {
+	d := &seafiletest.Data{
+		BlockSize: 8,
+		Libraries: []seafiletest.Library{
+			{Name: "Docs", Commits: []seafiletest.Commit{commitAt(0, map[string]string{"a.txt": "0123456789abcdef"})}},
+		},
+	}
+	counting := &countingFS{fsys: buildTestFS(t, d)}
+	_, sfs := openVerifyTestFile(t, counting, d, true)
+
+	f, err := sfs.Open("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	b := make([]byte, 1)
+	for i := 0; i < 10; i++ {
+		_, err = f.(io.ReaderAt).ReadAt(b, 9)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the block is read once to verify it, and then its checked contents are used
+	if counting.blockBytes != 8 {
+		t.Errorf("read %d bytes of blocks, want %d", counting.blockBytes, 8)
+	}
+}
+
+func TestVerifiedBlocksAreCheckedOnEveryRead(t *testing.T) {
+	d := &seafiletest.Data{
+		BlockSize: 8,
+		Libraries: []seafiletest.Library{
+			{Name: "Docs", Commits: []seafiletest.Commit{commitAt(0, map[string]string{"a.txt": "0123456789abcdef"})}},
+		},
+	}
+
+	for _, cacheSize := range []int64{seafile.DefaultBlockCacheSize, 0} {
+		m := buildTestFS(t, d)
+		s, sfs := openVerifyTestFile(t, m, d, true)
+		s.SetBlockCacheSize(cacheSize)
+
+		f, err := sfs.Open("a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		b := make([]byte, 4)
+		_, err = f.(io.ReaderAt).ReadAt(b, 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the disk then returns different bytes for the block, as a failing one might
+		for _, blockPath := range storedObjects(m, "blocks", d.Libraries[0].ID) {
+			if string(m[blockPath].Data) == "89abcdef" {
+				m[blockPath] = &fstest.MapFile{Data: []byte("89abXdef")}
+			}
+		}
+
+		_, err = f.(io.ReaderAt).ReadAt(b, 8)
+		if cacheSize > 0 {
+			// the copy that was checked is used
+			if err != nil || string(b) != "89ab" {
+				t.Errorf("cache size %d: read %q, %v after the block was damaged, want the checked copy", cacheSize, b, err)
+			}
+		} else if !errors.Is(err, seafile.ErrCorruptObject) {
+			// the block is read and checked again
+			t.Errorf("cache size %d: read %q, %v after the block was damaged, want ErrCorruptObject", cacheSize, b, err)
+		}
+	}
+}
+
+func TestCachedFSObjectsAreVerified(t *testing.T) {
+	d := &seafiletest.Data{
+		Libraries: []seafiletest.Library{
+			{Name: "Docs", Commits: []seafiletest.Commit{commitAt(0, map[string]string{"d/a.txt": "a"})}},
+		},
+	}
+	m := buildTestFS(t, d)
+	s, sfs := openVerifyTestFile(t, m, d, false)
+
+	// read everything into the cache without verifying it
+	_, err := sfs.ReadFile("d/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// then damage the file's fs object, in a way that still decodes
+	p, _ := someFileObject(t, m, d.Libraries[0].ID)
+	z, err := zlib.NewReader(bytes.NewReader(m[p].Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var damaged bytes.Buffer
+	w := zlib.NewWriter(&damaged)
+	w.Write(bytes.Replace(data, []byte(`"version"`), []byte(`"Version"`), 1))
+	w.Close()
+	m[p] = &fstest.MapFile{Data: damaged.Bytes()}
+
+	// while not verifying, the cached copy is fine to use
+	_, err = sfs.ReadFile("d/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// but once verification is turned on, it must be read and checked again
+	s.SetVerifyContent(true)
+	_, err = sfs.ReadFile("d/a.txt")
+	if !errors.Is(err, seafile.ErrCorruptObject) {
+		t.Errorf("reading a damaged file returned %v, want ErrCorruptObject", err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/thatoddmailbox/seafile-browse/seafile"
+)
+
+const sessionCookieName = "seafile-browse-session"
+
+// sessionTTL is how long a session lasts after it was last used, after which its libraries must be unlocked again.
+const sessionTTL = 30 * time.Minute
+
+// session holds the encrypted libraries that one browser has unlocked, so that giving a password only makes the
+// library readable to whoever gave it.
+type session struct {
+	lastUsed time.Time
+
+	// repos holds the unlocked Repos, by snapshot and repo ID
+	repos map[string]*seafile.Repo
+}
+
+var sessions map[string]*session = map[string]*session{}
+
+// sessionLock protects sessions, and the fields of each session
+var sessionLock sync.Mutex
+
+func sessionRepoKey(snapshot string, repoID string) string {
+	return snapshot + "/" + repoID
+}
+
+// getSession returns the session of the given request, or nil if it has none or it has expired.
+func getSession(r *http.Request) *session {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil
+	}
+
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	s, exists := sessions[cookie.Value]
+	if !exists {
+		return nil
+	}
+	if time.Since(s.lastUsed) > sessionTTL {
+		delete(sessions, cookie.Value)
+		return nil
+	}
+
+	s.lastUsed = time.Now()
+	return s
+}
+
+// startSession returns the session of the given request, starting a new one if needed.
+func startSession(w http.ResponseWriter, r *http.Request) (*session, error) {
+	s := getSession(r)
+	if s != nil {
+		return s, nil
+	}
+
+	idBytes := make([]byte, 32)
+	_, err := rand.Read(idBytes)
+	if err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(idBytes)
+
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	// forget the sessions that have expired, so that they don't pile up
+	for otherID, other := range sessions {
+		if time.Since(other.lastUsed) > sessionTTL {
+			delete(sessions, otherID)
+		}
+	}
+
+	s = &session{
+		lastUsed: time.Now(),
+		repos:    map[string]*seafile.Repo{},
+	}
+	sessions[id] = s
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return s, nil
+}
+
+// unlockedRepo returns the Repo with the given ID that was unlocked in this session, or nil if there isn't one.
+func (s *session) unlockedRepo(snapshot string, repoID string) *seafile.Repo {
+	if s == nil {
+		return nil
+	}
+
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	return s.repos[sessionRepoKey(snapshot, repoID)]
+}
+
+func (s *session) addUnlockedRepo(snapshot string, repoID string, repo *seafile.Repo) {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	s.repos[sessionRepoKey(snapshot, repoID)] = repo
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/thatoddmailbox/seafile-browse/config"
+)
+
+// formatSize formats a number of bytes for people to read. It can be negative, for the savings of encrypted
+// libraries, whose blocks are padded.
+func formatSize(s int64) string {
+	prefixes := []string{"", "K", "M", "G", "T", "P"}
+	prefix := 0
+	value := float64(s)
+	for math.Abs(value) >= 1000 && prefix < len(prefixes)-1 {
+		value /= 1000
+		prefix++
+	}
+
+	if prefix == 0 {
+		return strconv.FormatInt(s, 10) + " B"
+	}
+
+	return strconv.FormatFloat(value, 'f', 1, 64) + " " + prefixes[prefix] + "B"
+}
+
+func runSpace(args []string, cfg *config.Config) int {
+	flags := flag.NewFlagSet("space", flag.ExitOnError)
+	snapshot := flags.String("snapshot", "", "report on the given snapshot instead of the latest data")
+	flags.Parse(args)
+
+	storage, err := openStorage(*snapshot, cfg)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	repoIDs, err := storage.ListRepoIDs()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	usages, err := storage.Usage(repoIDs)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].PhysicalSize > usages[j].PhysicalSize
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "Library\tOwner\tHead\tWith history\tStored\tShared\tSaved by dedup\t")
+
+	var total int64
+	for _, usage := range usages {
+		inf, err := storage.GetRepoInfo(usage.RepoID)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+
+		name := inf.Name
+		if name == "" {
+			name = inf.ID
+		}
+		if inf.Garbage {
+			name += " (deleted)"
+		}
+
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			name,
+			inf.Owner,
+			formatSize(usage.HeadSize),
+			formatSize(usage.HistorySize),
+			formatSize(usage.PhysicalSize),
+			formatSize(usage.SharedSize),
+			formatSize(usage.DedupSavings()),
+		)
+
+		total += usage.PhysicalSize
+	}
+	w.Flush()
+
+	ownerUsages, err := storage.UsageByOwner(usages)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	fmt.Println()
+
+	w = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "Owner\tLibraries\tHead\tWith history\tStored\tShared\tSaved by dedup\t")
+	for _, ownerUsage := range ownerUsages {
+		owner := ownerUsage.Owner
+		if owner == "" {
+			owner = "(unknown)"
+		}
+
+		fmt.Fprintf(
+			w,
+			"%s\t%d\t%s\t%s\t%s\t%s\t%s\t\n",
+			owner,
+			ownerUsage.Repos,
+			formatSize(ownerUsage.HeadSize),
+			formatSize(ownerUsage.HistorySize),
+			formatSize(ownerUsage.PhysicalSize),
+			formatSize(ownerUsage.SharedSize),
+			formatSize(ownerUsage.DedupSavings()),
+		)
+	}
+	w.Flush()
+
+	fmt.Printf("\n%s stored in total.\n", formatSize(total))
+
+	return 0
+}
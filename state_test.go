@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thatoddmailbox/seafile-browse/seafile"
+	"github.com/thatoddmailbox/seafile-browse/seafile/seafiletest"
+)
+
+// switchingSource is a MetadataSource whose Metadata can be replaced, like a database that is changed.
+type switchingSource struct {
+	lock     sync.Mutex
+	metadata *seafile.Metadata
+}
+
+func (s *switchingSource) Metadata() (*seafile.Metadata, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.metadata, nil
+}
+
+func (s *switchingSource) set(m *seafile.Metadata) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.metadata = m
+}
+
+func TestStateRebuiltInBackground(t *testing.T) {
+	d := &seafiletest.Data{
+		Libraries: []seafiletest.Library{
+			{
+				Name:  "Docs",
+				Owner: "alice@example.com",
+				Commits: []seafiletest.Commit{
+					{Time: mirrorTestTime, Description: "Commit", Files: map[string]string{"a.txt": "a"}},
+				},
+			},
+		},
+	}
+	fsys, err := d.MapFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &switchingSource{metadata: d.Metadata()}
+	storage := seafile.NewStorageWithFS(fsys)
+	storage.SetMetadataSource(source)
+
+	state, err := buildState(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const snapshot = "state-test"
+	s := &snapshotStates{built: make(chan struct{}), live: storage, state: state}
+	close(s.built)
+	stateLock.Lock()
+	allStates[snapshot] = s
+	stateLock.Unlock()
+	defer func() {
+		stateLock.Lock()
+		delete(allStates, snapshot)
+		stateLock.Unlock()
+	}()
+
+	// nothing has changed, so the same state is used
+	got := getStateForSnapshot(snapshot, nil)
+	stateLock.Lock()
+	rebuilding := s.rebuilding
+	stateLock.Unlock()
+	if got.metadata != state.metadata || rebuilding {
+		t.Fatal("state was rebuilt without the metadata changing")
+	}
+
+	// once the library is renamed, the old state is returned while the new one is built
+	renamed := d.Metadata()
+	repo := renamed.Repos[d.Libraries[0].ID]
+	repo.Name = "Renamed"
+	renamed.Repos[d.Libraries[0].ID] = repo
+	source.set(renamed)
+
+	got = getStateForSnapshot(snapshot, nil)
+	if got.repoInfo[0].Name != "Docs" {
+		t.Errorf("got library %q while rebuilding, want the old name", got.repoInfo[0].Name)
+	}
+
+	// and the old state's storage still agrees with it
+	inf, err := got.storage.GetRepoInfo(d.Libraries[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inf.Name != "Docs" {
+		t.Errorf("the old state's storage sees library %q, want the old name", inf.Name)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		got = getStateForSnapshot(snapshot, nil)
+		if got.metadata == renamed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("state was not rebuilt")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if got.repoInfo[0].Name != "Renamed" {
+		t.Errorf("got library %q after rebuilding, want %q", got.repoInfo[0].Name, "Renamed")
+	}
+	if _, exists := got.repoFSs[d.Libraries[0].ID]; !exists {
+		t.Error("rebuilt state has no files for the library")
+	}
+}